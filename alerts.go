@@ -0,0 +1,69 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"log"
+)
+
+// checkAlert is an open alert of a check monitor, which is named after the check reported.
+type checkAlert struct {
+	mackerelAlert
+	name string
+}
+
+// openCheckAlerts lists the open alerts of the check monitors of the organization.
+// the monitors are listed at once, not to get each of them by the alerts.
+func openCheckAlerts(ctx context.Context, client *mackerelClient) ([]checkAlert, error) {
+	alerts, err := client.openAlerts(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var checks []checkAlert
+	var monitors map[string]string
+	for _, a := range alerts {
+		if a.Type != "check" {
+			continue
+		}
+		if monitors == nil {
+			if monitors, err = client.listMonitors(ctx); err != nil {
+				return nil, err
+			}
+		}
+		checks = append(checks, checkAlert{mackerelAlert: a, name: monitors[a.MonitorID]})
+	}
+	return checks, nil
+}
+
+// closeAlerts closes the open alerts of the check monitors of the OK reports,
+// to make the recovery visible even if the auto close of the monitor lags.
+func (f *forwarder) closeAlerts(ctx context.Context, reps Reports) error {
+	// the alerts are listed once for each organization
+	openAlerts := make(map[*mackerelClient][]checkAlert)
+	for _, rep := range reps.Reports {
+		if rep.Status != StatusOK {
+			continue
+		}
+		client := f.clientFor(rep.Source)
+		alerts, ok := openAlerts[client]
+		if !ok {
+			var err error
+			if alerts, err = openCheckAlerts(ctx, client); err != nil {
+				return err
+			}
+			openAlerts[client] = alerts
+		}
+
+		for _, a := range alerts {
+			if a.HostID != rep.Source.HostID || a.name != rep.Name {
+				continue
+			}
+			reason := fmt.Sprintf("%s is OK by CloudWatch alarm", rep.Name)
+			if err := client.closeAlert(ctx, a.ID, reason); err != nil {
+				return err
+			}
+			log.Printf("closed the alert %s of %s on %s", a.ID, rep.Name, rep.Source.HostID)
+		}
+	}
+	return nil
+}
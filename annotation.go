@@ -0,0 +1,22 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+)
+
+// annotate posts a graph annotation of the state change on ANNOTATION_SERVICE.
+func (f *forwarder) annotate(ctx context.Context, msg Alarm, rep Report) error {
+	title := fmt.Sprintf("%s is %s", rep.Name, msg.NewStateValue)
+	if msg.OldStateValue != "" {
+		title = fmt.Sprintf("%s: %s -> %s", rep.Name, msg.OldStateValue, msg.NewStateValue)
+	}
+	return f.client.createGraphAnnotation(ctx, graphAnnotation{
+		Title:       title,
+		Description: rep.Message,
+		From:        rep.OccurredAt,
+		To:          rep.OccurredAt,
+		Service:     f.conf.annotationService,
+		Roles:       f.conf.annotationRoles,
+	})
+}
@@ -7,7 +7,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -16,17 +15,17 @@ import (
 	"strings"
 	"time"
 
-	"github.com/apex/go-apex/sns"
 	"github.com/aws/aws-lambda-go/lambda"
 )
 
 const (
-	checkReportEndpoint = "https://api.mackerelio.com/api/v0/monitoring/checks/report"
-	reportMsgFmt        = "%s status is '%s', reason: %s, alarm_description: %s, state_change_time: %s, metrics: %s, namespace: %s"
+	checkReportPath = "/api/v0/monitoring/checks/report"
+	reportMsgFmt    = "%s status is '%s', reason: %s, alarm_description: %s, state_change_time: %s, metrics: %s, namespace: %s"
 
 	StatusOK       = "OK"
 	StatusWarning  = "WARNING"
 	StatusCritical = "CRITICAL"
+	StatusUnknown  = "UNKNOWN"
 )
 
 // https://mackerel.io/ja/api-docs/entry/check-monitoring
@@ -68,6 +67,12 @@ type Report struct {
 
 	// [optional] alert resent interval(min). default is not resending, and if it is less than 10 min, it is set 10 min.
 	NotificationInterval int `json:"notificationInterval,omitempty"`
+
+	// the alarm reported, nil if the report is not from an alarm (e.g. stale checks)
+	alarm *Alarm
+
+	// failed to post by the network errors or 5xx, not rejected by mackerel
+	unreachable bool
 }
 
 type Source struct {
@@ -76,9 +81,12 @@ type Source struct {
 
 	// mackerel host id
 	HostID string `json:"hostId"`
+
+	// [optional] API key of the organization of the host, MACKEREL_APIKEY is used by default.
+	apiKey string
 }
 
-// a content of record sent to lambd by SNS:
+// Alarm is a content of record sent to lambda by SNS:
 // {
 //   "AlarmName": "test",
 //   "AlarmDescription": "test",
@@ -109,21 +117,61 @@ type Source struct {
 //   }
 // }
 //
-type snsMessage struct {
+type Alarm struct {
 	AlarmName        string  `json:"AlarmName"`
 	AlarmDescription string  `json:"AlarmDescription"`
+	AlarmArn         string  `json:"AlarmArn"`
+	AWSAccountID     string  `json:"AWSAccountId"`
 	NewStateValue    string  `json:"NewStateValue"`
+	OldStateValue    string  `json:"OldStateValue"`
 	NewStateReason   string  `json:"NewStateReason"`
 	StateChangeTime  string  `json:"StateChangeTime"`
-	Trigger          trigger `json:"Trigger"`
+	Region           string  `json:"Region"` // like "Asia Pacific (Tokyo)"
+	Trigger          Trigger `json:"Trigger"`
+
+	// ARN of the SNS topic which delivered the alarm, when it is published, and the SNS MessageId
+	TopicArn    string    `json:"-"`
+	PublishedAt time.Time `json:"-"`
+	MessageID   string    `json:"-"`
+
+	// mackerel service and role of the alarmed resource, from its tags (RESOURCE_TAGS)
+	Service string `json:"-"`
+	Role    string `json:"-"`
+
+	// URL of the runbook, from the tag of the alarm (RUNBOOK_TAG)
+	Runbook string `json:"-"`
 }
 
-type trigger struct {
-	MetricName string `json:"MetricName"`
-	Namespace  string `json:"NameSpace"`
+type Trigger struct {
+	MetricName         string      `json:"MetricName"`
+	Namespace          string      `json:"NameSpace"`
+	StatisticType      string      `json:"StatisticType"`
+	Statistic          string      `json:"Statistic"`
+	Unit               *string     `json:"Unit"`
+	Dimensions         []Dimension `json:"Dimensions"`
+	Period             int         `json:"Period"`
+	EvaluationPeriods  int         `json:"EvaluationPeriods"`
+	ComparisonOperator string      `json:"ComparisonOperator"`
+	Threshold          float64     `json:"Threshold"`
+	TreatMissingData   string      `json:"TreatMissingData"`
+}
+
+type Dimension struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Dimension returns the value of the named dimension, or empty if the alarm has no such dimension.
+func (t Trigger) Dimension(name string) string {
+	for _, d := range t.Dimensions {
+		if d.Name == name {
+			return d.Value
+		}
+	}
+	return ""
 }
 
-func (m snsMessage) toMackerelStatus() string {
+func (m Alarm) toMackerelStatus() string {
 	if m.NewStateValue == StatusOK {
 		return StatusOK
 	}
@@ -133,103 +181,127 @@ func (m snsMessage) toMackerelStatus() string {
 	return StatusWarning
 }
 
+// causedByMissingData reports whether the alarm changed its state only because of missing datapoints.
+func (m Alarm) causedByMissingData() bool {
+	if m.NewStateValue == "INSUFFICIENT_DATA" {
+		return true
+	}
+	if m.NewStateValue != "ALARM" {
+		return false
+	}
+	// TreatMissingData is like "- TreatMissingData: Breaching"
+	if !strings.HasSuffix(strings.ToLower(m.Trigger.TreatMissingData), ": breaching") {
+		return false
+	}
+	// NewStateReason is like "Threshold Crossed: no datapoints were received for 1 period and 1 missing datapoint was treated as [Breaching]."
+	return strings.Contains(strings.ToLower(m.NewStateReason), "treated as [breaching]")
+}
+
 func ApexRun() {
 	if err := run(); err != nil {
 		log.Fatal(err)
 	}
 }
 
-func run() error {
-	apiKey, hostID, err := parseEnvVars()
-	if err != nil {
-		return err
+// ApexRunWithSourceResolvers is same as ApexRun, but the resolvers are tried before the ones configured by environment variables.
+// HOST_ID is used when no resolver resolves the sources.
+func ApexRunWithSourceResolvers(resolvers ...SourceResolver) {
+	if err := run(resolvers...); err != nil {
+		log.Fatal(err)
 	}
+}
 
-	handler := func(ctx context.Context, event *sns.Event) error {
-		reps := Reports{
-			Reports: make([]Report, 0, len(event.Records)),
-		}
-
-		for _, record := range event.Records {
-			var msg snsMessage
-			if err := json.Unmarshal([]byte(record.SNS.Message), &msg); err != nil {
-				log.Println(err)
-				continue
-			}
-
-			// empty is not expected, so skip.
-			if msg.AlarmName == "" || msg.NewStateValue == "" {
-				log.Printf("got the unknown message: %#v", msg)
-				continue
-			}
-
-			reps.Reports = append(reps.Reports, Report{
-				Source: Source{
-					HostID: hostID,
-					Type:   "host",
-				},
-				Name:   msg.AlarmName,
-				Status: msg.toMackerelStatus(),
-				Message: fmt.Sprintf(reportMsgFmt,
-					msg.AlarmName,
-					msg.NewStateValue,
-					msg.NewStateReason,
-					msg.AlarmDescription,
-					msg.StateChangeTime,
-					msg.Trigger.MetricName,
-					msg.Trigger.Namespace,
-				),
-				OccurredAt: time.Now().Unix(),
-			})
+func run(resolvers ...SourceResolver) error {
+	if !inLambda() {
+		if err := loadEnvFile(); err != nil {
+			return err
 		}
-
-		return PostChecksReport(apiKey, reps)
+	}
+	l, err := newLoader(resolvers)
+	if err != nil {
+		return err
+	}
+	f, err := l.load(context.Background())
+	if err != nil {
+		return err
 	}
 
-	lambda.Start(handler)
+	if setupAsyncFlag() && !inLambda() {
+		return f.conf.asyncInvoke.setupAsync(context.Background())
+	}
+	if configFlag() && !inLambda() {
+		return l.printEffective(context.Background(), os.Stdin, os.Stdout)
+	}
+	if f.conf.outputMode == outputStdout && !inLambda() {
+		return f.handleStdin(context.Background())
+	}
+	lambda.Start(l.handle)
 
 	return nil
 }
 
-func parseEnvVars() (apiKey, hostID string, err error) {
-	if hostID = os.Getenv("HOST_ID"); hostID == "" {
-		err = errors.New("HOST_ID is required")
-		return
+// PostChecksReport posts the reports in the chunks, retrying 5xx and network errors with the exponential backoff.
+func PostChecksReport(apiKey string, reps Reports) error {
+	var errs batchErrors
+	for _, chunk := range chunkReports(reps) {
+		if _, _, err := retryChecksReport(context.Background(), defaultRetryPolicy, apiBaseURL, apiKey, chunk); err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return errs.err()
+}
 
-	if apiKey = os.Getenv("MACKEREL_APIKEY"); apiKey == "" {
-		err = errors.New("MACKEREL_APIKEY is required")
-		return
-	}
+// retryChecksReport posts the reports by the policy, retrying 5xx, 429 and network errors by default.
+// the status code of the last try and the number of tries are returned too.
+func retryChecksReport(ctx context.Context, p retryPolicy, baseURL, apiKey string, reps Reports) (int, int, error) {
+	var code int
+	attempts, err := p.do(ctx, func() error {
+		var err error
+		code, err = postChecksReport(ctx, baseURL, apiKey, reps)
+		if err != nil && !p.retryable(code) {
+			return permanentError{err}
+		}
+		return err
+	})
+	return code, attempts, err
+}
 
-	return
+// rejected reports whether the reports are rejected by 4xx, which will not be fixed by retrying, except 429 by the rate limit.
+func rejected(code int) bool {
+	return code >= 400 && code < 500 && code != http.StatusTooManyRequests
 }
 
-func PostChecksReport(apiKey string, reps Reports) error {
+// postChecksReport posts to the API of baseURL, and returns the status code of the response too, or 0 if no response.
+func postChecksReport(ctx context.Context, baseURL, apiKey string, reps Reports) (int, error) {
 	body := new(bytes.Buffer)
 	if err := json.NewEncoder(body).Encode(reps); err != nil {
-		return err
+		return 0, err
 	}
-	req, err := http.NewRequest(http.MethodPost, checkReportEndpoint, body)
+	req, cancel, err := newRequest(ctx, http.MethodPost, baseURL+checkReportPath, body)
 	if err != nil {
-		return err
+		return 0, err
 	}
+	defer cancel()
 
 	req.Header.Set("Content-type", "application/json")
 	req.Header.Set("X-Api-Key", apiKey)
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := sendRequest(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
 
 	if status := resp.StatusCode; status >= 400 {
 		body, err := ioutil.ReadAll(resp.Body)
 		if err != nil {
-			return fmt.Errorf("failed to read response body: status code %d %s", status, err)
+			return status, fmt.Errorf("failed to read response body: status code %d %s", status, err)
 		}
-		return fmt.Errorf("failed to post: status code %d %s", status, string(body))
+		err = fmt.Errorf("failed to post: status code %d %s", status, string(body))
+		if status == http.StatusTooManyRequests {
+			return status, rateLimitError{error: err, after: retryAfter(resp.Header.Get("Retry-After"))}
+		}
+		return status, err
 	}
 
-	return nil
+	return resp.StatusCode, nil
 }
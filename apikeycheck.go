@@ -0,0 +1,51 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+)
+
+// actions for the API keys found invalid at the cold start (VALIDATE_APIKEYS)
+const (
+	validateWarn = "warn"
+	validateFail = "fail"
+)
+
+// validateAPIKeys calls GET /api/v0/org by each API key configured, and logs the name of the organization,
+// so that an invalid key is found when the settings are loaded, not by a 401 on the first alarm.
+// the error is returned only by VALIDATE_APIKEYS=fail at the cold start, for the keys rejected by 401 or 403.
+// the other errors (e.g. an outage of mackerel) are only logged, not to block the retries riding it out.
+func (f *forwarder) validateAPIKeys(ctx context.Context, coldStart bool) error {
+	if f.conf.validateAPIKeys == "" || f.conf.outputMode == outputStdout {
+		return nil
+	}
+	keys := f.conf.apiKeys()
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var errs batchErrors
+	for _, id := range ids {
+		// the key is identified by the hash, not to log the key itself
+		org, err := f.clientFor(Source{apiKey: keys[id]}).getOrg(ctx)
+		if e, ok := err.(*apiError); ok && (e.statusCode == http.StatusUnauthorized || e.statusCode == http.StatusForbidden) {
+			log.Printf("the API key %s is invalid: %s", id, err)
+			errs = append(errs, fmt.Errorf("the API key %s is invalid: %s", id, err))
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to validate the API key %s: %s", id, err)
+			continue
+		}
+		log.Printf("the API key %s is of the organization %s", id, org.Name)
+	}
+	if f.conf.validateAPIKeys != validateFail || !coldStart {
+		return nil
+	}
+	return errs.err()
+}
@@ -0,0 +1,95 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/appconfigdata"
+)
+
+// defaultAppConfigReload is CONFIG_RELOAD_SECONDS for AppConfig, as the default poll interval of AppConfig.
+const defaultAppConfigReload = 60 * time.Second
+
+// appConfigSource reads CONFIG_FILE like "appconfig:<application>/<environment>/<profile>" from AWS AppConfig.
+// it keeps the session, and the content is fetched only when the poll interval told by AppConfig passed,
+// because AppConfig returns no content while the configuration is not changed.
+type appConfigSource struct {
+	application string
+	environment string
+	profile     string
+
+	mu       sync.Mutex
+	token    *string
+	content  []byte
+	nextPoll time.Time
+}
+
+func newAppConfigSource(path string) (*appConfigSource, error) {
+	ids := strings.Split(strings.TrimPrefix(path, "appconfig:"), "/")
+	if len(ids) != 3 || ids[0] == "" || ids[1] == "" || ids[2] == "" {
+		return nil, fmt.Errorf("CONFIG_FILE must be like appconfig:<application>/<environment>/<profile>, but got %q", path)
+	}
+	return &appConfigSource{application: ids[0], environment: ids[1], profile: ids[2]}, nil
+}
+
+func (s *appConfigSource) read(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.content != nil && time.Now().Before(s.nextPoll) {
+		return s.content, nil
+	}
+
+	client := appconfigdata.New(awsSession())
+	if s.token == nil {
+		out, err := client.StartConfigurationSessionWithContext(ctx, &appconfigdata.StartConfigurationSessionInput{
+			ApplicationIdentifier:          aws.String(s.application),
+			EnvironmentIdentifier:          aws.String(s.environment),
+			ConfigurationProfileIdentifier: aws.String(s.profile),
+		})
+		if err != nil {
+			return nil, err
+		}
+		s.token = out.InitialConfigurationToken
+	}
+	out, err := client.GetLatestConfigurationWithContext(ctx, &appconfigdata.GetLatestConfigurationInput{
+		ConfigurationToken: s.token,
+	})
+	if err != nil {
+		// the token expires in 24 hours, so start a new session next time
+		s.token = nil
+		return nil, err
+	}
+	s.token = out.NextPollConfigurationToken
+	if sec := aws.Int64Value(out.NextPollIntervalInSeconds); sec > 0 {
+		s.nextPoll = time.Now().Add(time.Duration(sec) * time.Second)
+	}
+	// empty if not changed since the last call
+	if len(out.Configuration) > 0 {
+		s.content = out.Configuration
+	}
+	return s.content, nil
+}
+
+// unwrapFeatureFlag converts a feature flag of AppConfig like {"enabled": true, "value": "..."} to the value of the setting.
+// a disabled flag unsets the setting, and an enabled flag without the value is "true".
+func unwrapFeatureFlag(v interface{}) interface{} {
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return v
+	}
+	enabled, ok := m["enabled"].(bool)
+	if !ok {
+		return v
+	}
+	if !enabled {
+		return nil
+	}
+	if value, ok := m["value"]; ok {
+		return value
+	}
+	return true
+}
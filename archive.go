@@ -0,0 +1,78 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/firehose"
+)
+
+// maxFirehoseBatch is the limit of PutRecordBatch.
+const maxFirehoseBatch = 500
+
+// archived is a record written to the delivery stream, a line of JSON to query with Athena.
+type archived struct {
+	ReceivedAt int64           `json:"received_at"`
+	TopicArn   string          `json:"topic_arn"`
+	Message    json.RawMessage `json:"message"`
+	Reports    []Report        `json:"reports"`
+}
+
+// archiver streams the received alarms and the reports to the Kinesis Data Firehose delivery stream.
+type archiver struct {
+	firehose *firehose.Firehose
+	stream   string
+}
+
+func newArchiver(stream string) *archiver {
+	return &archiver{
+		firehose: firehose.New(awsSession()),
+		stream:   stream,
+	}
+}
+
+func newArchived(topicArn, message string, reps []Report) archived {
+	if reps == nil {
+		reps = []Report{}
+	}
+	return archived{
+		ReceivedAt: time.Now().Unix(),
+		TopicArn:   topicArn,
+		Message:    json.RawMessage(message),
+		Reports:    reps,
+	}
+}
+
+func (a *archiver) put(ctx context.Context, items []archived) error {
+	for len(items) > 0 {
+		n := len(items)
+		if n > maxFirehoseBatch {
+			n = maxFirehoseBatch
+		}
+		records := make([]*firehose.Record, 0, n)
+		for _, item := range items[:n] {
+			b, err := json.Marshal(item)
+			if err != nil {
+				return err
+			}
+			records = append(records, &firehose.Record{Data: append(b, '\n')})
+		}
+		out, err := a.firehose.PutRecordBatchWithContext(ctx, &firehose.PutRecordBatchInput{
+			DeliveryStreamName: aws.String(a.stream),
+			Records:            records,
+		})
+		if err != nil {
+			return err
+		}
+		if failed := aws.Int64Value(out.FailedPutCount); failed > 0 {
+			return fmt.Errorf("failed to put %d records to %s", failed, a.stream)
+		}
+		log.Printf("archived %d alarms to %s", n, a.stream)
+		items = items[n:]
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package cwa2mkr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/lambda"
+)
+
+// asyncInvokeConfig is the async invocation config of this lambda itself, configured by -setup-async flag.
+type asyncInvokeConfig struct {
+	function string
+
+	// [optional] the defaults of lambda if nil
+	maxRetries  *int64
+	maxEventAge *int64
+
+	// [optional] ARN of SQS, SNS, lambda or EventBridge bus to send the events failed
+	onFailure string
+}
+
+// setupAsyncFlag reports whether the command line has -setup-async flag, to configure the async invocation by the settings.
+func setupAsyncFlag() bool {
+	return commandFlag("setup-async")
+}
+
+func parseAsyncInvokeConfig(getenv func(string) string) (asyncInvokeConfig, error) {
+	c := asyncInvokeConfig{function: getenv("FUNCTION_NAME")}
+	if c.function == "" {
+		c.function = os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	}
+	if s := getenv("ASYNC_MAX_RETRIES"); s != "" {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || n < 0 || n > 2 {
+			return c, errors.New("ASYNC_MAX_RETRIES must be 0, 1 or 2")
+		}
+		c.maxRetries = aws.Int64(n)
+	}
+	if s := getenv("ASYNC_MAX_EVENT_AGE_SECONDS"); s != "" {
+		sec, err := strconv.ParseInt(s, 10, 64)
+		if err != nil || sec < 60 || sec > 21600 {
+			return c, errors.New("ASYNC_MAX_EVENT_AGE_SECONDS must be seconds from 60 to 21600")
+		}
+		c.maxEventAge = aws.Int64(sec)
+	}
+	if c.onFailure = getenv("ASYNC_ON_FAILURE"); c.onFailure != "" && !strings.HasPrefix(c.onFailure, "arn:") {
+		return c, fmt.Errorf("ASYNC_ON_FAILURE must be an ARN: %s", c.onFailure)
+	}
+	return c, nil
+}
+
+// setupAsync puts the async invocation config of the function, so that the delivery does not depend on the config written by hand.
+// the settings not given are reset to the defaults of lambda.
+func (c asyncInvokeConfig) setupAsync(ctx context.Context) error {
+	if c.function == "" {
+		return errors.New("FUNCTION_NAME is required to setup the async invocation")
+	}
+	in := &lambda.PutFunctionEventInvokeConfigInput{
+		FunctionName:             aws.String(c.function),
+		MaximumRetryAttempts:     c.maxRetries,
+		MaximumEventAgeInSeconds: c.maxEventAge,
+	}
+	if c.onFailure != "" {
+		in.DestinationConfig = &lambda.DestinationConfig{
+			OnFailure: &lambda.OnFailure{Destination: aws.String(c.onFailure)},
+		}
+	}
+	out, err := lambda.New(awsSession()).PutFunctionEventInvokeConfigWithContext(ctx, in)
+	if err != nil {
+		return fmt.Errorf("failed to put the async invocation config of %s: %s", c.function, err)
+	}
+	log.Printf("configured the async invocation of %s: %s", aws.StringValue(out.FunctionArn), out)
+	return nil
+}
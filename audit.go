@@ -0,0 +1,129 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+// auditRecord is a report posted to mackerel, with the result.
+type auditRecord struct {
+	Name       string `json:"name"`
+	HostID     string `json:"host_id"`
+	Status     string `json:"status"`
+	OccurredAt int64  `json:"occurred_at"`
+	PostedAt   int64  `json:"posted_at"`
+
+	// status code of the response, 0 if no response
+	StatusCode int    `json:"status_code"`
+	Error      string `json:"error,omitempty"`
+}
+
+func newAuditRecords(reps Reports, code int, err error) []auditRecord {
+	now := time.Now()
+	records := make([]auditRecord, 0, len(reps.Reports))
+	for _, rep := range reps.Reports {
+		r := auditRecord{
+			Name:       rep.Name,
+			HostID:     rep.Source.HostID,
+			Status:     rep.Status,
+			OccurredAt: rep.OccurredAt,
+			PostedAt:   now.UnixNano(),
+			StatusCode: code,
+		}
+		if err != nil {
+			r.Error = err.Error()
+		}
+		records = append(records, r)
+	}
+	return records
+}
+
+type auditWriter interface {
+	write(ctx context.Context, records []auditRecord) error
+}
+
+// dynamoAudit writes an item for each report.
+//
+// table schema:
+//   - partition key: "name" (S)
+//   - sort key: "posted_at" (N, unix nano)
+type dynamoAudit struct {
+	db    *dynamodb.DynamoDB
+	table string
+}
+
+func newDynamoAudit(table string) *dynamoAudit {
+	return &dynamoAudit{
+		db:    dynamodb.New(awsSession()),
+		table: table,
+	}
+}
+
+func (a *dynamoAudit) write(ctx context.Context, records []auditRecord) error {
+	for _, r := range records {
+		item := map[string]*dynamodb.AttributeValue{
+			"name":        {S: aws.String(r.Name)},
+			"posted_at":   {N: aws.String(strconv.FormatInt(r.PostedAt, 10))},
+			"host_id":     {S: aws.String(r.HostID)},
+			"status":      {S: aws.String(r.Status)},
+			"occurred_at": {N: aws.String(strconv.FormatInt(r.OccurredAt, 10))},
+			"status_code": {N: aws.String(strconv.Itoa(r.StatusCode))},
+		}
+		if r.Error != "" {
+			item["error"] = &dynamodb.AttributeValue{S: aws.String(r.Error)}
+		}
+		if _, err := a.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(a.table),
+			Item:      item,
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// s3Audit writes an object of JSON lines for each post, with the key like "<prefix>2006/01/02/<unix nano>-<request id>.json".
+type s3Audit struct {
+	s3     *s3.S3
+	bucket string
+	prefix string
+}
+
+func newS3Audit(bucket, prefix string) *s3Audit {
+	return &s3Audit{
+		s3:     s3.New(awsSession()),
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+func (a *s3Audit) write(ctx context.Context, records []auditRecord) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, r := range records {
+		if err := enc.Encode(r); err != nil {
+			return err
+		}
+	}
+
+	key := datedKey(ctx, a.prefix)
+	_, err := a.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(a.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b.Bytes()),
+		ContentType: aws.String("application/x-ndjson"),
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("wrote the audit trail to s3://%s/%s", a.bucket, key)
+	return nil
+}
@@ -0,0 +1,20 @@
+package cwa2mkr
+
+import (
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+var (
+	awsSess     *session.Session
+	awsSessOnce sync.Once
+)
+
+// awsSession returns the session shared by AWS service clients.
+func awsSession() *session.Session {
+	awsSessOnce.Do(func() {
+		awsSess = session.Must(session.NewSession())
+	})
+	return awsSess
+}
@@ -0,0 +1,202 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/elbv2"
+)
+
+// awsIntegration tells how to find the host registered by mackerel AWS integration.
+// the host is named after the resource, which is the value of one of the dimensions.
+type awsIntegration struct {
+	// meta.cloud.provider of the host
+	provider string
+
+	// dimensions to look up in order
+	dimensions []string
+
+	// [optional] converts the dimension value to the resource id. the dimension value is used as is by default.
+	resource func(ctx context.Context, msg Alarm, dimension, value string) (string, error)
+
+	// [optional] converts the resource id to the host name. the resource id is used as is by default.
+	hostName func(resource string) string
+
+	// [optional] formats of the resource ARNs by the dimensions, with region, account id and the resource id.
+	// the host whose custom identifier is the ARN is looked up, when no host is found by the name.
+	arnFormats map[string]string
+
+	// [optional] the dimension qualifying the values of the others, e.g. the cluster of a service
+	scope string
+}
+
+// awsIntegrations are keyed by CloudWatch namespace.
+var awsIntegrations = map[string]awsIntegration{
+	// alarms of ECS services are reported to the host of the service (e.g. registered by the container agent),
+	// or to the host of the cluster. the service is looked up by "cluster/service" as the resource.
+	"AWS/ECS": {
+		provider:   "ecs",
+		dimensions: []string{"ServiceName", "ClusterName"},
+		resource:   ecsResource,
+		hostName: func(resource string) string {
+			return resource[strings.LastIndex(resource, "/")+1:]
+		},
+		arnFormats: map[string]string{
+			"ServiceName": "arn:aws:ecs:%s:%s:service/%s",
+			"ClusterName": "arn:aws:ecs:%s:%s:cluster/%s",
+		},
+		scope: "ClusterName",
+	},
+
+	"AWS/RDS": {
+		provider:   "rds",
+		dimensions: []string{"DBInstanceIdentifier"},
+		arnFormats: map[string]string{"DBInstanceIdentifier": "arn:aws:rds:%s:%s:db:%s"},
+	},
+
+	"AWS/Lambda": {
+		provider:   "lambda",
+		dimensions: []string{"FunctionName"},
+		arnFormats: map[string]string{"FunctionName": "arn:aws:lambda:%s:%s:function:%s"},
+	},
+
+	// LoadBalancer is like "app/my-alb/1234567890abcdef", and the host is named "my-alb".
+	// alarms only with TargetGroup are reported to the host of the load balancer of the target group.
+	"AWS/ApplicationELB": {
+		provider:   "alb",
+		dimensions: []string{"LoadBalancer", "TargetGroup"},
+		resource:   albResource,
+		hostName: func(resource string) string {
+			parts := strings.Split(resource, "/")
+			if len(parts) != 3 {
+				return resource
+			}
+			return parts[1]
+		},
+		// the resource of TargetGroup is the load balancer too
+		arnFormats: map[string]string{
+			"LoadBalancer": "arn:aws:elasticloadbalancing:%s:%s:loadbalancer/%s",
+			"TargetGroup":  "arn:aws:elasticloadbalancing:%s:%s:loadbalancer/%s",
+		},
+	},
+}
+
+// ecsResource qualifies ServiceName dimension by the cluster, which is a part of the ARN of the service.
+func ecsResource(ctx context.Context, msg Alarm, dimension, value string) (string, error) {
+	if dimension != "ServiceName" {
+		return value, nil
+	}
+	cluster := msg.Trigger.Dimension("ClusterName")
+	if cluster == "" {
+		return "", nil
+	}
+	return cluster + "/" + value, nil
+}
+
+// albResource converts TargetGroup dimension to the load balancer, by the elbv2 API.
+func albResource(ctx context.Context, msg Alarm, dimension, value string) (string, error) {
+	if dimension != "TargetGroup" {
+		return value, nil
+	}
+	region, account := alarmRegionAccount(msg.AlarmArn)
+	if region == "" {
+		return "", nil
+	}
+	svc := elbv2.New(awsSession(), aws.NewConfig().WithRegion(region))
+	out, err := svc.DescribeTargetGroupsWithContext(ctx, &elbv2.DescribeTargetGroupsInput{
+		TargetGroupArns: []*string{
+			aws.String(fmt.Sprintf("arn:aws:elasticloadbalancing:%s:%s:%s", region, account, value)),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	for _, tg := range out.TargetGroups {
+		for _, lb := range tg.LoadBalancerArns {
+			// arn:aws:elasticloadbalancing:region:account:loadbalancer/app/my-alb/1234567890abcdef
+			if i := strings.Index(aws.StringValue(lb), ":loadbalancer/"); i >= 0 {
+				return aws.StringValue(lb)[i+len(":loadbalancer/"):], nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// awsIntegrationResolver resolves the hosts of the AWS resources alarmed.
+type awsIntegrationResolver struct {
+	client *mackerelClient
+	cache  *hostCache
+}
+
+func (r *awsIntegrationResolver) resolveHost(ctx context.Context, msg Alarm) (string, error) {
+	integration, ok := awsIntegrations[msg.Trigger.Namespace]
+	if !ok {
+		return "", nil
+	}
+
+	for _, dim := range integration.dimensions {
+		value := msg.Trigger.Dimension(dim)
+		if value == "" {
+			continue
+		}
+		key := "aws:" + integration.provider + ":" + dim + "=" + value
+		if integration.scope != "" && integration.scope != dim {
+			key += "," + integration.scope + "=" + msg.Trigger.Dimension(integration.scope)
+		}
+		hostID, err := r.cache.lookup(ctx, key, func(ctx context.Context) (string, error) {
+			resource := value
+			if integration.resource != nil {
+				var err error
+				if resource, err = integration.resource(ctx, msg, dim, value); err != nil || resource == "" {
+					return "", err
+				}
+			}
+			name := resource
+			if integration.hostName != nil {
+				name = integration.hostName(resource)
+			}
+
+			hostID, err := r.find(ctx, integration.provider, url.Values{"name": {name}})
+			arnFormat := integration.arnFormats[dim]
+			if err != nil || hostID != "" || arnFormat == "" {
+				return hostID, err
+			}
+			region, account := alarmRegionAccount(msg.AlarmArn)
+			if region == "" {
+				return "", nil
+			}
+			arn := fmt.Sprintf(arnFormat, region, account, resource)
+			return r.find(ctx, integration.provider, url.Values{"customIdentifier": {arn}})
+		})
+		if err != nil || hostID != "" {
+			return hostID, err
+		}
+	}
+	return "", nil
+}
+
+func (r *awsIntegrationResolver) find(ctx context.Context, provider string, query url.Values) (string, error) {
+	hosts, err := r.client.findHosts(ctx, query)
+	if err != nil {
+		return "", err
+	}
+	for _, h := range hosts {
+		if h.provider() == provider {
+			return h.ID, nil
+		}
+	}
+	return "", nil
+}
+
+// alarmRegionAccount returns the region and the account id of the alarm ARN
+// like "arn:aws:cloudwatch:ap-northeast-1:123456789012:alarm:name".
+func alarmRegionAccount(alarmArn string) (region, account string) {
+	parts := strings.SplitN(alarmArn, ":", 6)
+	if len(parts) < 6 {
+		return "", ""
+	}
+	return parts[3], parts[4]
+}
@@ -0,0 +1,62 @@
+package cwa2mkr
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAWSIntegrationECS(t *testing.T) {
+	tests := []struct {
+		name       string
+		dimensions []Dimension
+		// the custom identifier of the host
+		arn    string
+		hostID string
+	}{
+		{
+			"service",
+			[]Dimension{{Name: "ClusterName", Value: "cluster"}, {Name: "ServiceName", Value: "service"}},
+			"arn:aws:ecs:ap-northeast-1:123456789012:service/cluster/service",
+			"service-host",
+		},
+		{
+			"cluster",
+			[]Dimension{{Name: "ClusterName", Value: "cluster"}},
+			"arn:aws:ecs:ap-northeast-1:123456789012:cluster/cluster",
+			"cluster-host",
+		},
+		{
+			"service of the cluster",
+			[]Dimension{{Name: "ClusterName", Value: "cluster"}, {Name: "ServiceName", Value: "service"}},
+			"arn:aws:ecs:ap-northeast-1:123456789012:cluster/cluster",
+			"cluster-host",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mackerel := newFakeServer(t, func(call fakeCall) (int, interface{}) {
+				var hosts []map[string]interface{}
+				if call.op == "GET /api/v0/hosts" && call.query.Get("customIdentifier") == tt.arn {
+					hosts = append(hosts, map[string]interface{}{
+						"id":   tt.hostID,
+						"meta": map[string]interface{}{"cloud": map[string]string{"provider": "ecs"}},
+					})
+				}
+				return 200, map[string]interface{}{"hosts": hosts}
+			})
+			f := testForwarder(t, mackerel, nil)
+			r := &awsIntegrationResolver{client: f.client, cache: newHostCache(defaultHostCacheTTL, "")}
+
+			msg := testAlarm("ecs", "ALARM")
+			msg.Trigger.Namespace = "AWS/ECS"
+			msg.Trigger.Dimensions = tt.dimensions
+			hostID, err := r.resolveHost(context.Background(), msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hostID != tt.hostID {
+				t.Errorf("resolved %q, want %q", hostID, tt.hostID)
+			}
+		})
+	}
+}
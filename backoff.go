@@ -0,0 +1,143 @@
+package cwa2mkr
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	// POST_RETRY_JITTER
+	jitterFull  = "full"
+	jitterEqual = "equal"
+	jitterNone  = "none"
+)
+
+// retryPolicy retries with the exponential backoff and the jitter.
+type retryPolicy struct {
+	attempts int
+
+	// the wait before the nth retry is base * 2^(n-1) capped by max, randomized by the jitter (full by default)
+	base   time.Duration
+	max    time.Duration
+	jitter string
+
+	// [optional] the total time of the tries, bounded by the deadline of the context anyway
+	deadline time.Duration
+
+	// [optional] the HTTP status codes to retry, instead of 5xx and 429. network errors are always retried.
+	statusCodes []int
+}
+
+// retryable reports whether the failure of the HTTP status code (0 if no response) should be retried.
+func (p retryPolicy) retryable(code int) bool {
+	if code == 0 || len(p.statusCodes) == 0 {
+		return !rejected(code)
+	}
+	for _, c := range p.statusCodes {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// rateLimitError is retried after Retry-After, or the max wait of the policy if unknown.
+type rateLimitError struct {
+	error
+	after time.Duration
+}
+
+// retryAfter parses Retry-After header of seconds or HTTP date, 0 if unknown.
+func retryAfter(s string) time.Duration {
+	if s == "" {
+		return 0
+	}
+	if sec, err := strconv.Atoi(s); err == nil && sec >= 0 {
+		return time.Duration(sec) * time.Second
+	}
+	if t, err := http.ParseTime(s); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// defaultRetryPolicy is for the outputs, and for mackerel unless POST_MAX_ATTEMPTS or POST_RETRY_DEADLINE_SECONDS is set.
+var defaultRetryPolicy = retryPolicy{attempts: sendAttempts, base: sendInterval, max: sendMaxWait}
+
+// retry calls fn until it succeeds or returns permanentError, up to attempts times by defaultRetryPolicy.
+// the number of tries is returned too.
+func retry(ctx context.Context, attempts int, fn func() error) (int, error) {
+	p := defaultRetryPolicy
+	p.attempts = attempts
+	return p.do(ctx, fn)
+}
+
+// do calls fn until it succeeds or returns permanentError, up to the attempts.
+// rateLimitError is retried after the time told by the server, or given up if it is longer than the max wait.
+// it gives up without waiting if the next try would be after the deadline.
+func (p retryPolicy) do(ctx context.Context, fn func() error) (int, error) {
+	var until time.Time
+	if p.deadline > 0 {
+		until = time.Now().Add(p.deadline)
+	}
+	if d, ok := ctx.Deadline(); ok && (until.IsZero() || d.Before(until)) {
+		until = d
+	}
+
+	var err error
+	for i := 1; ; i++ {
+		if err = fn(); err == nil {
+			return i, nil
+		}
+		if p, ok := err.(permanentError); ok {
+			return i, p.error
+		}
+		if i >= p.attempts {
+			return i, err
+		}
+		wait := p.wait(i)
+		if limited, ok := err.(rateLimitError); ok {
+			// conservatively, if the server does not tell when
+			if wait = limited.after; wait <= 0 {
+				wait = p.max
+			}
+			// not to block for long without the deadline, e.g. PostChecksReport by context.Background()
+			if p.max > 0 && wait > p.max {
+				return i, err
+			}
+		}
+		if !until.IsZero() && time.Now().Add(wait).After(until) {
+			return i, err
+		}
+		select {
+		case <-ctx.Done():
+			return i, err
+		case <-time.After(wait):
+		}
+	}
+}
+
+// wait returns the duration to wait before the nth retry.
+// full jitter is random up to the backoff, equal jitter is the half of it and random up to the rest.
+func (p retryPolicy) wait(n int) time.Duration {
+	d := p.base << uint(n-1)
+	if d <= 0 || (p.max > 0 && d > p.max) {
+		d = p.max
+	}
+	if d <= 0 {
+		return 0
+	}
+	switch p.jitter {
+	case jitterNone:
+		return d
+	case jitterEqual:
+		half := d / 2
+		return half + time.Duration(rand.Int63n(int64(d-half)))
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
@@ -0,0 +1,38 @@
+package cwa2mkr
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyRateLimited(t *testing.T) {
+	tests := []struct {
+		name  string
+		after time.Duration
+		tries int
+	}{
+		{"within the max wait", 5 * time.Millisecond, 3},
+		{"not told", 0, 3},
+		{"longer than the max wait", time.Hour, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := retryPolicy{attempts: 3, base: time.Millisecond, max: 10 * time.Millisecond}
+			start := time.Now()
+			tries, err := p.do(context.Background(), func() error {
+				return rateLimitError{error: errors.New("429"), after: tt.after}
+			})
+			if err == nil {
+				t.Fatal("no error")
+			}
+			if tries != tt.tries {
+				t.Errorf("%d tries, want %d", tries, tt.tries)
+			}
+			if d := time.Since(start); d > time.Second {
+				t.Errorf("blocked for %s", d)
+			}
+		})
+	}
+}
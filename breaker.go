@@ -0,0 +1,90 @@
+package cwa2mkr
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const defaultBreakerCooldown = 60 * time.Second
+
+// errCircuitOpen is returned by post while the circuit breaker is open, and the reports go to the dead letters.
+var errCircuitOpen = errors.New("the circuit breaker is open after the consecutive failures to post to mackerel")
+
+// circuitBreaker short-circuits posting to mackerel for the cooldown after the consecutive failures,
+// not to waste the time of the invocations during the outages of mackerel.
+// it is kept while the lambda container is warm, and shared by the forwarders posting to the same API. nil is always closed.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// circuitBreaker returns the breaker of MACKEREL_APIURL shared by the forwarders, even reloaded, or nil not to break.
+// the thresholds of the breaker are updated by the latest settings.
+func (l *loader) circuitBreaker(conf *config) *circuitBreaker {
+	if conf.breakerThreshold <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.breakers == nil {
+		l.breakers = make(map[string]*circuitBreaker)
+	}
+	b := l.breakers[conf.apiURL]
+	if b == nil {
+		b = newCircuitBreaker(conf.breakerThreshold, conf.breakerCooldown)
+		l.breakers[conf.apiURL] = b
+		return b
+	}
+	b.mu.Lock()
+	b.threshold, b.cooldown = conf.breakerThreshold, conf.breakerCooldown
+	b.mu.Unlock()
+	return b
+}
+
+// allow reports whether to try posting. after the cooldown, it is half-open and only a try is allowed as the probe.
+// the others are short-circuited for another cooldown, while the probe closes it by a success or opens it again by a failure.
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failures < b.threshold {
+		return true
+	}
+	now := time.Now()
+	if now.Before(b.openUntil) {
+		return false
+	}
+	// the probe, and another one is allowed after the cooldown if it never records
+	b.openUntil = now.Add(b.cooldown)
+	return true
+}
+
+// record counts the consecutive failures, only the ones which may be an outage (5xx, 429 and network errors).
+func (b *circuitBreaker) record(failed bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !failed {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+	if b.failures++; b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+		log.Printf("the circuit breaker is open for %s after %d consecutive failures", b.cooldown, b.failures)
+	}
+}
@@ -0,0 +1,83 @@
+package cwa2mkr
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	b := newCircuitBreaker(2, 10*time.Millisecond)
+	b.record(true)
+	if !b.allow() {
+		t.Fatal("open before the threshold")
+	}
+	b.record(true)
+	if b.allow() {
+		t.Fatal("closed after the threshold")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if b.allow() {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 1 {
+		t.Fatalf("%d probes allowed while half-open, want 1", allowed)
+	}
+
+	b.record(false)
+	if !b.allow() || !b.allow() {
+		t.Error("not closed by the probe succeeded")
+	}
+}
+
+func TestLoaderSharesCircuitBreaker(t *testing.T) {
+	l := &loader{}
+	conf := &config{apiURL: "https://api.mackerelio.com", breakerThreshold: 1, breakerCooldown: time.Minute}
+	b := l.circuitBreaker(conf)
+	if got := l.circuitBreaker(&config{apiURL: conf.apiURL, breakerThreshold: 3, breakerCooldown: time.Minute}); got != b {
+		t.Error("another breaker for the same API")
+	}
+	if b.threshold != 3 {
+		t.Errorf("threshold %d, want the latest 3", b.threshold)
+	}
+	if l.circuitBreaker(&config{apiURL: "https://mackerel.example.com", breakerThreshold: 1}) == b {
+		t.Error("the breaker shared by the other API")
+	}
+	if l.circuitBreaker(&config{apiURL: conf.apiURL}) != nil {
+		t.Error("a breaker without CIRCUIT_BREAKER_FAILURES")
+	}
+}
+
+func TestCircuitOpenInBatchErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		open bool
+	}{
+		{"open", errCircuitOpen, true},
+		{"failed", errors.New("failed to post"), false},
+		{"a chunk open", batchErrors{errors.New("failed to post"), errCircuitOpen}, true},
+		{"chunks failed", batchErrors{errors.New("failed to post"), errors.New("failed to post")}, false},
+		{"an organization open", batchErrors{fmt.Errorf("org: %w", errCircuitOpen)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errors.Is(tt.err, errCircuitOpen); got != tt.open {
+				t.Errorf("errors.Is(%v, errCircuitOpen) = %v, want %v", tt.err, got, tt.open)
+			}
+		})
+	}
+}
@@ -0,0 +1,247 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/go-apex/sns"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	// BUFFER_MODE
+	bufferReceiver = "receiver" // validates and enqueues the SNS records to BUFFER_QUEUE_URL
+	bufferPoster   = "poster"   // posts the records drained from BUFFER_QUEUE_URL
+
+	// SendMessageBatch sends up to 10 messages
+	maxBufferBatch = 10
+)
+
+// bufferQueue decouples the ingestion spikes by SNS from the capacity of the mackerel API,
+// the receiver enqueues the records and the poster drains them by the event source mapping.
+type bufferQueue struct {
+	sqs      *sqs.SQS
+	queueURL string
+}
+
+func newBufferQueue(queueURL string) *bufferQueue {
+	return &bufferQueue{
+		sqs:      sqs.New(awsSession()),
+		queueURL: queueURL,
+	}
+}
+
+// owns reports whether the ARN of the event source is the queue, like arn:aws:sqs:region:account:name for https://sqs.region.amazonaws.com/account/name.
+func (q *bufferQueue) owns(arn string) bool {
+	name := q.queueURL[strings.LastIndex(q.queueURL, "/")+1:]
+	return name != "" && strings.HasSuffix(arn, ":"+name)
+}
+
+func (q *bufferQueue) enqueue(ctx context.Context, records []*sns.Record) error {
+	for len(records) > 0 {
+		n := len(records)
+		if n > maxBufferBatch {
+			n = maxBufferBatch
+		}
+		entries := make([]*sqs.SendMessageBatchRequestEntry, 0, n)
+		for i, record := range records[:n] {
+			b, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			entries = append(entries, &sqs.SendMessageBatchRequestEntry{
+				Id:          aws.String(strconv.Itoa(i)),
+				MessageBody: aws.String(string(b)),
+			})
+		}
+		out, err := q.sqs.SendMessageBatchWithContext(ctx, &sqs.SendMessageBatchInput{
+			QueueUrl: aws.String(q.queueURL),
+			Entries:  entries,
+		})
+		if err != nil {
+			return err
+		}
+		if len(out.Failed) > 0 {
+			f := out.Failed[0]
+			return fmt.Errorf("failed to enqueue %d records to the buffer: %s %s", len(out.Failed), aws.StringValue(f.Code), aws.StringValue(f.Message))
+		}
+		records = records[n:]
+	}
+	return nil
+}
+
+// receive validates the records and enqueues them to the buffer, without calling mackerel.
+// the unknown messages are dropped here unless UNKNOWN_MESSAGE_ACTION tells to fail or report them, which the poster does.
+func (f *forwarder) receive(ctx context.Context, event *sns.Event) error {
+	records := make([]*sns.Record, 0, len(event.Records))
+	for _, record := range event.Records {
+		var msg Alarm
+		err := json.Unmarshal([]byte(record.SNS.Message), &msg)
+		if err == nil && (msg.AlarmName == "" || msg.NewStateValue == "") {
+			err = errEmptyAlarm
+		}
+		if err != nil && (f.conf.unknownAction == "" || f.conf.unknownAction == unknownSkip) {
+			log.Printf("skip the unknown message %s: %s", record.SNS.MessageID, err)
+			statsFrom(ctx).skipped()
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if err := f.buffer.enqueue(ctx, records); err != nil {
+		return err
+	}
+	log.Printf("enqueued %d records to the buffer", len(records))
+	return nil
+}
+
+// bufferedRecord is an SNS record enqueued by the receiver, with the id of the SQS message.
+type bufferedRecord struct {
+	messageID string
+	record    *sns.Record
+}
+
+// bufferedRecords returns the SNS records in the event from BUFFER_QUEUE_URL, or nil if the event is not from it.
+// the broken messages are skipped, which are never parsed by redriving.
+func (f *forwarder) bufferedRecords(payload []byte) []bufferedRecord {
+	if f.buffer == nil || f.conf.bufferMode != bufferPoster {
+		return nil
+	}
+	var e struct {
+		Records []struct {
+			sqsRecord
+			EventSourceARN string `json:"eventSourceARN"`
+		} `json:"Records"`
+	}
+	if err := json.Unmarshal(payload, &e); err != nil || len(e.Records) == 0 || e.Records[0].EventSource != "aws:sqs" || !f.buffer.owns(e.Records[0].EventSourceARN) {
+		return nil
+	}
+	records := make([]bufferedRecord, 0, len(e.Records))
+	for _, r := range e.Records {
+		var record sns.Record
+		if err := json.Unmarshal([]byte(r.Body), &record); err != nil {
+			log.Printf("skip the message %s of the buffer broken: %s", r.MessageID, err)
+			continue
+		}
+		records = append(records, bufferedRecord{messageID: r.MessageID, record: &record})
+	}
+	return records
+}
+
+// handleBuffered posts the records drained from the buffer together, by the forwarders for the overrides of each record.
+// the records of the failed forwarders are returned as batchItemFailures by BUFFER_BATCH_ITEM_FAILURES,
+// not to redrive the others posted already. or the errors fail the whole batch.
+func (l *loader) handleBuffered(ctx context.Context, f *forwarder, records []bufferedRecord) (*sqsBatchResponse, error) {
+	var (
+		forwarders []*forwarder
+		events     = make(map[*forwarder]*sns.Event)
+		messageIDs = make(map[*forwarder][]string)
+		errs       batchErrors
+		failures   []sqsItemFailure
+	)
+	fail := func(ids []string, err error) {
+		errs = append(errs, err)
+		for _, id := range ids {
+			log.Printf("failed to post the message %s of the buffer: %s", id, err)
+			failures = append(failures, sqsItemFailure{ItemIdentifier: id})
+		}
+	}
+	for _, r := range records {
+		payload, err := json.Marshal(sns.Event{Records: []*sns.Record{r.record}})
+		if err != nil {
+			fail([]string{r.messageID}, err)
+			continue
+		}
+		of, err := l.forwarderFor(ctx, f, payload)
+		if err != nil {
+			log.Printf("failed to override the settings for the record, so use the settings as is: %s", err)
+			of = f
+		}
+		if events[of] == nil {
+			events[of] = &sns.Event{}
+			forwarders = append(forwarders, of)
+		}
+		events[of].Records = append(events[of].Records, r.record)
+		messageIDs[of] = append(messageIDs[of], r.messageID)
+	}
+
+	for _, of := range forwarders {
+		payload, err := json.Marshal(events[of])
+		if err != nil {
+			fail(messageIDs[of], err)
+			continue
+		}
+		if _, err := of.handle(ctx, payload); err != nil {
+			fail(messageIDs[of], err)
+		}
+	}
+	if !f.conf.bufferBatchItemFailures {
+		return nil, errs.err()
+	}
+	return &sqsBatchResponse{BatchItemFailures: append([]sqsItemFailure{}, failures...)}, nil
+}
+
+// rateLimiter paces posting to mackerel by POST_RATE_LIMIT reports per second, shared by the forwarders.
+type rateLimiter struct {
+	interval time.Duration // per report
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newRateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{interval: time.Second / time.Duration(perSecond)}
+}
+
+// rateLimiter returns the limiter of the rate shared by the forwarders, even reloaded, or nil not to limit.
+func (l *loader) rateLimiter(perSecond int) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.limiters == nil {
+		l.limiters = make(map[int]*rateLimiter)
+	}
+	if l.limiters[perSecond] == nil {
+		l.limiters[perSecond] = newRateLimiter(perSecond)
+	}
+	return l.limiters[perSecond]
+}
+
+// wait waits until n reports can be posted, or ctx is done.
+func (r *rateLimiter) wait(ctx context.Context, n int) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	now := time.Now()
+	if r.next.Before(now) {
+		r.next = now
+	}
+	at := r.next
+	r.next = r.next.Add(time.Duration(n) * r.interval)
+	r.mu.Unlock()
+
+	if d := time.Until(at); d > 0 {
+		select {
+		case <-ctx.Done():
+			return errors.New("the invocation is about to time out while waiting for the rate limit")
+		case <-time.After(d):
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package cwa2mkr
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	defaultHostCacheTTL = 5 * time.Minute
+
+	// not found results are cached shorter, so that new hosts are found soon.
+	negativeCacheTTL = time.Minute
+
+	// the lookups failed definitely (4xx of mackerel) are cached in memory for a while, not to call the APIs on every record by an alarm storm.
+	failureCacheTTL = 30 * time.Second
+
+	// the items are pruned over this, in a warm container
+	maxHostCacheItems = 10000
+)
+
+// hostCache caches the results of host lookups in memory, and in DynamoDB to share them between lambda instances.
+// empty host id (not found) is also cached, and the definite errors of resolving too, only in memory.
+//
+// table schema:
+//   - partition key: "key" (S)
+//   - "expires_at" (N) can be used as the TTL attribute of DynamoDB.
+type hostCache struct {
+	ttl time.Duration
+
+	mu    sync.Mutex
+	items map[string]cachedHost
+
+	db    *dynamodb.DynamoDB
+	table string
+}
+
+type cachedHost struct {
+	hostID    string
+	expiresAt time.Time
+
+	// the error of the failed lookup, which is not shared via DynamoDB
+	err error
+}
+
+func newHostCache(ttl time.Duration, table string) *hostCache {
+	c := &hostCache{
+		ttl:   ttl,
+		items: make(map[string]cachedHost),
+		table: table,
+	}
+	if table != "" {
+		c.db = dynamodb.New(awsSession())
+	}
+	return c
+}
+
+// lookup returns the cached host id of the key, or calls resolve and caches its result.
+func (c *hostCache) lookup(ctx context.Context, key string, resolve func(context.Context) (string, error)) (string, error) {
+	now := time.Now()
+
+	c.mu.Lock()
+	item, ok := c.items[key]
+	c.mu.Unlock()
+	if ok && now.Before(item.expiresAt) {
+		return item.hostID, item.err
+	}
+
+	if c.db != nil {
+		item, ok, err := c.get(ctx, key)
+		if err != nil {
+			// the cache is not essential
+			log.Printf("failed to get the host cache of %s: %s", key, err)
+		} else if ok && now.Before(item.expiresAt) {
+			c.set(key, item)
+			return item.hostID, nil
+		}
+	}
+
+	hostID, err := resolve(ctx)
+	if err != nil {
+		// the timeouts, the network errors and 5xx may be fixed by the next try
+		if e, ok := err.(*apiError); ok && rejected(e.statusCode) {
+			c.set(key, cachedHost{expiresAt: now.Add(failureCacheTTL), err: err})
+		}
+		return "", err
+	}
+
+	item = cachedHost{hostID: hostID, expiresAt: now.Add(c.ttl)}
+	if hostID == "" && c.ttl > negativeCacheTTL {
+		item.expiresAt = now.Add(negativeCacheTTL)
+	}
+	c.set(key, item)
+	if c.db != nil {
+		if err := c.put(ctx, key, item); err != nil {
+			log.Printf("failed to put the host cache of %s: %s", key, err)
+		}
+	}
+
+	return hostID, nil
+}
+
+func (c *hostCache) set(key string, item cachedHost) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.items) >= maxHostCacheItems {
+		now := time.Now()
+		for k, v := range c.items {
+			if !now.Before(v.expiresAt) {
+				delete(c.items, k)
+			}
+		}
+		if len(c.items) >= maxHostCacheItems {
+			c.items = make(map[string]cachedHost)
+		}
+	}
+	c.items[key] = item
+}
+
+func (c *hostCache) get(ctx context.Context, key string) (cachedHost, bool, error) {
+	out, err := c.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(c.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+	})
+	if err != nil || out.Item == nil {
+		return cachedHost{}, false, err
+	}
+	expiresAt, err := strconv.ParseInt(attrNumber(out.Item["expires_at"]), 10, 64)
+	if err != nil {
+		return cachedHost{}, false, nil
+	}
+	return cachedHost{
+		hostID:    attrString(out.Item["host_id"]),
+		expiresAt: time.Unix(expiresAt, 0),
+	}, true, nil
+}
+
+func (c *hostCache) put(ctx context.Context, key string, item cachedHost) error {
+	_, err := c.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(c.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":        {S: aws.String(key)},
+			"host_id":    {S: aws.String(item.hostID)},
+			"expires_at": {N: aws.String(strconv.FormatInt(item.expiresAt.Unix(), 10))},
+		},
+	})
+	return err
+}
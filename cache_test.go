@@ -0,0 +1,45 @@
+package cwa2mkr
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestHostCacheFailures(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		cached bool
+	}{
+		{"canceled", context.Canceled, false},
+		{"deadline", context.DeadlineExceeded, false},
+		{"network", errors.New("connection refused"), false},
+		{"5xx", &apiError{statusCode: http.StatusInternalServerError}, false},
+		{"429", &apiError{statusCode: http.StatusTooManyRequests}, false},
+		{"403", &apiError{statusCode: http.StatusForbidden}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newHostCache(defaultHostCacheTTL, "")
+			calls := 0
+			resolve := func(ctx context.Context) (string, error) {
+				calls++
+				return "", tt.err
+			}
+			for i := 0; i < 2; i++ {
+				if _, err := c.lookup(context.Background(), "key", resolve); err != tt.err {
+					t.Errorf("err = %v, want %v", err, tt.err)
+				}
+			}
+			want := 2
+			if tt.cached {
+				want = 1
+			}
+			if calls != want {
+				t.Errorf("resolved %d times, want %d", calls, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package cwa2mkr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// limits of a request to post the checks report
+	maxChunkReports = 100
+	maxChunkBytes   = 512 * 1024
+
+	// the window of occurredAt, mackerel rejects or mishandles the reports far from now
+	maxOccurredAtAge  = 6 * time.Hour
+	maxOccurredAtSkew = 5 * time.Minute
+
+	// the head of the message noting the original time of occurredAt clamped
+	occurredAtNote = "[occurred at "
+)
+
+// occurredAtHead notes the original time of occurredAt clamped, at the head of the message.
+func occurredAtHead(at int64) string {
+	return occurredAtNote + time.Unix(at, 0).UTC().Format(time.RFC3339) + "] "
+}
+
+// chunkReports splits the reports into the chunks up to maxChunkReports reports and maxChunkBytes encoded,
+// to post an alarm storm in several requests. a report larger than maxChunkBytes is a chunk alone.
+func chunkReports(reps Reports) []Reports {
+	if len(reps.Reports) <= 1 {
+		return []Reports{reps}
+	}
+	var (
+		chunks []Reports
+		chunk  Reports
+		size   int
+	)
+	for _, rep := range reps.Reports {
+		b, err := json.Marshal(rep)
+		n := len(b) + 1 // with the comma
+		if err != nil {
+			n = 0 // fails to post anyway
+		}
+		if len(chunk.Reports) > 0 && (len(chunk.Reports) >= maxChunkReports || size+n > maxChunkBytes) {
+			chunks = append(chunks, chunk)
+			chunk, size = Reports{}, 0
+		}
+		chunk.Reports = append(chunk.Reports, rep)
+		size += n
+	}
+	return append(chunks, chunk)
+}
+
+// checkReports validates the reports by the limits of mackerel before sending, to log the actionable errors instead of the opaque 400.
+// occurredAt out of the window (e.g. of the events replayed) is clamped with the original time at the head of the message,
+// the messages too long (e.g. of the stale checks or the unknown messages) are truncated, and the invalid reports are returned
+// with the errors, not to be sent.
+func checkReports(reps Reports) (Reports, Reports, batchErrors) {
+	var (
+		valid, invalid Reports
+		errs           batchErrors
+	)
+	for _, rep := range reps.Reports {
+		if err := checkReport(rep); err != nil {
+			log.Printf("%s of %s is invalid, so not sent: %s", rep.Name, rep.Source.HostID, err)
+			invalid.Reports = append(invalid.Reports, rep)
+			errs = append(errs, fmt.Errorf("%s of %s is invalid: %s", rep.Name, rep.Source.HostID, err))
+			continue
+		}
+		if at, ok := clampOccurredAt(rep.OccurredAt, time.Now()); ok {
+			head := occurredAtHead(rep.OccurredAt)
+			log.Printf("clamped occurredAt of %s on %s, %s", rep.Name, rep.Source.HostID, strings.TrimSpace(head))
+			rep.OccurredAt = at
+			// not noted twice when retried again
+			if !strings.HasPrefix(rep.Message, occurredAtNote) {
+				rep.Message = head + truncateMiddle(rep.Message, maxMessageLength-utf8.RuneCountInString(head))
+			}
+		}
+		if n := utf8.RuneCountInString(rep.Message); n > maxMessageLength {
+			log.Printf("truncated the message of %s on %s, %d characters over the limit %d", rep.Name, rep.Source.HostID, n, maxMessageLength)
+			rep.Message = string([]rune(rep.Message)[:maxMessageLength-len(ellipsis)]) + ellipsis
+		}
+		valid.Reports = append(valid.Reports, rep)
+	}
+	return valid, invalid, errs
+}
+
+func checkReport(rep Report) error {
+	switch {
+	case rep.Name == "":
+		return errors.New("the name is empty")
+	case rep.Source.HostID == "":
+		return errors.New("the host id is empty")
+	case rep.Source.Type != "host":
+		return fmt.Errorf("the source type must be host: %q", rep.Source.Type)
+	case rep.OccurredAt <= 0:
+		return fmt.Errorf("occurredAt is invalid: %d", rep.OccurredAt)
+	}
+	switch rep.Status {
+	case StatusOK, StatusWarning, StatusCritical, StatusUnknown:
+	default:
+		return fmt.Errorf("the status must be OK, WARNING, CRITICAL or UNKNOWN: %q", rep.Status)
+	}
+	if b, err := json.Marshal(rep); err != nil {
+		return err
+	} else if len(b) > maxChunkBytes {
+		return fmt.Errorf("the report is %d bytes, over the limit %d", len(b), maxChunkBytes)
+	}
+	return nil
+}
+
+// truncateMiddle truncates s to limit characters by cutting the middle, to keep the links at the tail of the message.
+func truncateMiddle(s string, limit int) string {
+	r := []rune(s)
+	if len(r) <= limit {
+		return s
+	}
+	head := (limit - len(ellipsis)) / 2
+	tail := limit - len(ellipsis) - head
+	return string(r[:head]) + ellipsis + string(r[len(r)-tail:])
+}
+
+// clampOccurredAt clamps the epoch seconds out of the window from maxOccurredAtAge ago to maxOccurredAtSkew later to now,
+// not to the edge of the window, from which the retries later would fall out again. it reports whether it is clamped.
+func clampOccurredAt(at int64, now time.Time) (int64, bool) {
+	if min := now.Add(-maxOccurredAtAge).Unix(); at < min {
+		return now.Unix(), true
+	}
+	if max := now.Add(maxOccurredAtSkew).Unix(); at > max {
+		return now.Unix(), true
+	}
+	return at, false
+}
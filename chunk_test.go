@@ -0,0 +1,81 @@
+package cwa2mkr
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestClampOccurredAt(t *testing.T) {
+	now := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		at      time.Time
+		want    time.Time
+		clamped bool
+	}{
+		{"now", now, now, false},
+		{"in the window", now.Add(-time.Hour), now.Add(-time.Hour), false},
+		{"at the oldest", now.Add(-maxOccurredAtAge), now.Add(-maxOccurredAtAge), false},
+		{"too old", now.Add(-maxOccurredAtAge - time.Second), now, true},
+		{"replayed", now.Add(-72 * time.Hour), now, true},
+		{"skewed a little", now.Add(maxOccurredAtSkew), now.Add(maxOccurredAtSkew), false},
+		{"in the future", now.Add(maxOccurredAtSkew + time.Second), now, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, clamped := clampOccurredAt(tt.at.Unix(), now)
+			if got != tt.want.Unix() || clamped != tt.clamped {
+				t.Errorf("got %s %v, want %s %v", time.Unix(got, 0).UTC(), clamped, tt.want, tt.clamped)
+			}
+			// clamped once, left as is by the retries later
+			if _, again := clampOccurredAt(got, now.Add(time.Hour)); tt.clamped && again {
+				t.Errorf("clamped again by the retry an hour later")
+			}
+		})
+	}
+}
+
+func TestCheckReportsNotesOccurredAt(t *testing.T) {
+	link := " https://console.aws.amazon.com/cloudwatch/home#alarmsV2:alarm/test"
+	old := time.Now().Add(-24 * time.Hour).Unix()
+	tests := []struct {
+		name    string
+		message string
+	}{
+		{"short", "test status is 'ALARM'" + link},
+		{"at the limit", strings.Repeat("x", maxMessageLength-len(link)) + link},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reps := Reports{Reports: []Report{{
+				Source:     Source{Type: "host", HostID: "host"},
+				Name:       "test",
+				Status:     StatusCritical,
+				Message:    tt.message,
+				OccurredAt: old,
+			}}}
+			valid, invalid, _ := checkReports(reps)
+			if len(valid.Reports) != 1 || len(invalid.Reports) != 0 {
+				t.Fatalf("valid %d, invalid %d", len(valid.Reports), len(invalid.Reports))
+			}
+			m := valid.Reports[0].Message
+			if !strings.HasPrefix(m, occurredAtHead(old)) {
+				t.Errorf("not noted: %s", m)
+			}
+			if !strings.HasSuffix(m, link) {
+				t.Errorf("the link is cut: %s", m)
+			}
+			if n := utf8.RuneCountInString(m); n > maxMessageLength {
+				t.Errorf("%d characters over the limit", n)
+			}
+
+			// noted once by the retries
+			again, _, _ := checkReports(valid)
+			if again.Reports[0].Message != m {
+				t.Errorf("noted again: %s", again.Reports[0].Message)
+			}
+		})
+	}
+}
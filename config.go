@@ -0,0 +1,881 @@
+package cwa2mkr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// actions for the alarms caused by missing data
+const (
+	missingDataUnknown = "unknown"
+	missingDataSkip    = "skip"
+)
+
+type config struct {
+	apiKey string
+
+	// the settings parsed, to validate them again by the self test
+	getenv func(string) string
+
+	// the API keys of the other organizations by names, see credentials.go
+	credentials credentials
+
+	// check the API keys by GET /api/v0/org when the settings are loaded, see apikeycheck.go
+	validateAPIKeys string
+
+	// base URL of the mackerel API, https://api.mackerelio.com by default
+	apiURL string
+
+	// retry posting to mackerel, see backoff.go
+	postRetry retryPolicy
+
+	// short-circuit posting after the consecutive failures, see breaker.go
+	breakerThreshold int
+	breakerCooldown  time.Duration
+
+	// overrides HTTPS_PROXY and HTTP_PROXY, see proxy.go
+	proxyURL   *url.URL
+	noProxy    string
+	httpClient *http.Client
+
+	// timeout of each HTTP request, see proxy.go
+	httpTimeout time.Duration
+
+	// confirm the statuses took effect after posting, see verify.go
+	verifyDelivery bool
+	verifyDelay    time.Duration
+
+	// the time reserved to save the reports not posted before the invocation times out, see flush.go
+	flushReserve time.Duration
+
+	// "stdout" or empty (post to mackerel), see stdout.go
+	outputMode string
+
+	// HOST_ID can be a comma separated list to post the same report to each host
+	hostIDs []string
+
+	// SSM parameter to save the id of the pseudo host, see pseudohost.go
+	hostIDParameter string
+
+	// roll up several alarms into one check, see group.go
+	groupPattern    *regexp.Regexp
+	groupDimension  string
+	groupStateTable string
+
+	// the message of the reports, see message.go
+	messageTemplate *template.Template
+
+	// the fields of the message if no template, see message.go
+	messageFields []string
+
+	// append the URL of the alarm in the CloudWatch console to the message
+	consoleLink bool
+
+	// render the state with the previous one like "OK → ALARM"
+	stateTransition bool
+
+	// the tag of the alarms of the URL of the runbook in the message, see resourcetags.go
+	runbookTag  string
+	runbookTags *tagCache
+
+	// how to truncate a long message, see message.go
+	messageTruncation string
+
+	// "now", "state_change_time" or "sns_timestamp" for OccurredAt, see timezone.go
+	occurredAtSource string
+
+	// render StateChangeTime in the messages, see timezone.go
+	timeLocation *time.Location
+	timeFormat   string
+
+	// the name of the checks, see rewrite.go
+	nameTemplate *template.Template
+
+	// rewrite check names, see rewrite.go
+	rewriteRules []*rewriteRule
+
+	// map the states of the alarms to the statuses, see statusmap.go
+	statusMappings []*statusMapping
+
+	// "skip" (default), "fail" or "report" for the messages not parsed as alarms, see unknown.go
+	unknownAction string
+
+	// "unknown", "skip" or empty (report as usual)
+	missingData string
+
+	// adjust the status by NewStateReason, see severity.go
+	reasonRules []*reasonRule
+
+	// notificationInterval of the reports, see interval.go
+	intervalRules   []*intervalRule
+	defaultInterval int
+
+	// the settings overridden for each SNS topic, see layers.go
+	topicSettings map[string]map[string]string
+
+	// tried in order before HOST_ID, see host.go
+	resolvers []SourceResolver
+	hostCache *hostCache
+
+	// mackerel service and role from the tags of the alarmed resources, see resourcetags.go
+	resourceTags *tagCache
+	serviceTag   string
+	roleTag      string
+
+	// used when resolving the host fails
+	fallbackHostID string
+
+	// save the reports failed to post, see deadletter.go
+	deadLetterBucket   string
+	deadLetterPrefix   string
+	deadLetterQueueURL string
+
+	// never post the same alarm twice, see idempotency.go
+	idempotencyTable string
+	idempotencyTTL   time.Duration
+	idempotencyKey   string
+
+	// receiver or poster of the two-stage buffering, see buffer.go
+	bufferMode              string
+	bufferQueueURL          string
+	bufferBatchItemFailures bool
+	postRateLimit           int
+
+	// alert the operators after the consecutive failures to deliver, see opsalert.go
+	opsTopic         string
+	opsAlertFailures int
+
+	// write the reports before posting, see outbox.go
+	outboxTable       string
+	outboxMaxAttempts int
+
+	// at_least_once or exactly_once by IDEMPOTENCY_TABLE and RETRY_QUEUE_URL
+	deliveryMode string
+
+	// defer posting the failed reports by SQS, see retryqueue.go
+	retryQueueURL    string
+	retryDelay       int64
+	retryMaxAttempts int
+
+	// report the messages failed to retry by the partial batch response
+	retryBatchItemFailures bool
+
+	// record the posted reports to DynamoDB or S3, see audit.go
+	auditTable  string
+	auditBucket string
+	auditPrefix string
+
+	// configured by -setup-async flag, see asyncinvoke.go
+	asyncInvoke asyncInvokeConfig
+
+	// CloudWatch namespace of the metrics of this lambda itself, see emf.go
+	selfMetricsNamespace string
+
+	// Firehose delivery stream to archive the alarms, see archive.go
+	firehoseStream string
+
+	// SNS topic to republish the alarms after posted, see republish.go
+	republishTopic string
+
+	// the outputs other than mackerel like Slack, webhooks, Opsgenie and PagerDuty, see notifier.go
+	notifiers []notifier
+
+	// post all reports to other organizations too, see mirror.go
+	mirrorOrgs []mirrorOrg
+
+	// "skip", "fallback" (to HOST_ID) or empty (not check), see retired.go
+	retiredAction string
+
+	// report the alarms whose host is not resolved to the service, see fallback.go
+	fallbackService string
+	fallbackRoles   []string
+	fallbackMode    string
+
+	// keep the last report of checks, see state.go
+	stateTable string
+	staleAfter time.Duration
+
+	// post the daily summary of STATE_TABLE, see digest.go
+	digestTopic    string
+	digestSlackURL string
+
+	// list the checks not OK in the dashboard, see dashboard.go
+	dashboardID          string
+	dashboardWidgetTitle string
+
+	// write the states to S3 for a status page, see snapshot.go
+	snapshotBucket string
+	snapshotKey    string
+
+	// post the statuses as service metrics, see metric.go
+	stateMetricService string
+	hostStateMetrics   bool
+
+	// post graph annotations on state changes, see annotation.go
+	annotationService string
+	annotationRoles   []string
+	annotationOnly    bool
+
+	// close the alerts of the checks on OK, see alerts.go
+	closeAlerts bool
+
+	// create downtimes instead of reporting the maintenance alarms, see maintenance.go
+	maintenance *maintenance
+
+	// "skip", "downgrade" or empty (ignore downtimes)
+	downtimeAction string
+}
+
+// parseEnvVars parses the environment variables through the layers, over the settings in CONFIG_FILE.
+// the references to secrets are resolved by the cache.
+func parseEnvVars(ctx context.Context, secrets *secretCache, layers settingLayers) (*config, error) {
+	getenv, secretErr := secrets.resolving(ctx, layers.lookup)
+	apiKey, err := secrets.apiKey(ctx, getenv)
+	if err != nil {
+		return nil, err
+	}
+	conf, err := parseConfig(func(name string) string {
+		if name == "MACKEREL_APIKEY" {
+			return apiKey
+		}
+		return getenv(name)
+	})
+	if err := secretErr(); err != nil {
+		return nil, err
+	}
+	return conf, err
+}
+
+// parseConfig parses the settings named as the environment variables.
+func parseConfig(getenv func(string) string) (*config, error) {
+	conf := &config{getenv: getenv}
+
+	for _, id := range strings.Split(getenv("HOST_ID"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			conf.hostIDs = append(conf.hostIDs, id)
+		}
+	}
+	conf.hostIDParameter = getenv("HOST_ID_PARAMETER")
+
+	if s := getenv("TOPIC_SETTINGS"); s != "" {
+		topics, err := parseTopicSettings(s)
+		if err != nil {
+			return nil, fmt.Errorf("TOPIC_SETTINGS is invalid: %s", err)
+		}
+		conf.topicSettings = topics
+	}
+
+	switch conf.outputMode = getenv("OUTPUT_MODE"); conf.outputMode {
+	case "", outputStdout:
+	default:
+		return nil, fmt.Errorf("OUTPUT_MODE must be %q", outputStdout)
+	}
+	if stdoutFlag() {
+		conf.outputMode = outputStdout
+	}
+
+	if conf.apiURL = strings.TrimSuffix(getenv("MACKEREL_APIURL"), "/"); conf.apiURL == "" {
+		conf.apiURL = apiBaseURL
+	} else if u, err := url.Parse(conf.apiURL); err != nil || u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("MACKEREL_APIURL must be a URL like %s", apiBaseURL)
+	}
+
+	conf.postRetry = defaultRetryPolicy
+	if s := getenv("POST_MAX_ATTEMPTS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, errors.New("POST_MAX_ATTEMPTS must be a positive integer")
+		}
+		conf.postRetry.attempts = n
+	}
+	if s := getenv("POST_RETRY_DEADLINE_SECONDS"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec <= 0 {
+			return nil, errors.New("POST_RETRY_DEADLINE_SECONDS must be seconds")
+		}
+		conf.postRetry.deadline = time.Duration(sec) * time.Second
+	}
+	if s := getenv("POST_RETRY_BASE_MILLISECONDS"); s != "" {
+		ms, err := strconv.Atoi(s)
+		if err != nil || ms <= 0 {
+			return nil, errors.New("POST_RETRY_BASE_MILLISECONDS must be milliseconds")
+		}
+		conf.postRetry.base = time.Duration(ms) * time.Millisecond
+	}
+	if s := getenv("POST_RETRY_MAX_WAIT_SECONDS"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec <= 0 {
+			return nil, errors.New("POST_RETRY_MAX_WAIT_SECONDS must be seconds")
+		}
+		conf.postRetry.max = time.Duration(sec) * time.Second
+	}
+	switch conf.postRetry.jitter = getenv("POST_RETRY_JITTER"); conf.postRetry.jitter {
+	case "", jitterFull, jitterEqual, jitterNone:
+	default:
+		return nil, fmt.Errorf("POST_RETRY_JITTER must be %q, %q or %q", jitterFull, jitterEqual, jitterNone)
+	}
+	if s := getenv("POST_RETRY_STATUS_CODES"); s != "" {
+		for _, c := range strings.Split(s, ",") {
+			code, err := strconv.Atoi(strings.TrimSpace(c))
+			if err != nil || code < 400 || code > 599 {
+				return nil, fmt.Errorf("POST_RETRY_STATUS_CODES is invalid: %q is not a status code of errors", c)
+			}
+			conf.postRetry.statusCodes = append(conf.postRetry.statusCodes, code)
+		}
+	}
+
+	if s := getenv("CIRCUIT_BREAKER_FAILURES"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, errors.New("CIRCUIT_BREAKER_FAILURES must be a positive integer")
+		}
+		conf.breakerThreshold = n
+		conf.breakerCooldown = defaultBreakerCooldown
+		if s := getenv("CIRCUIT_BREAKER_COOLDOWN_SECONDS"); s != "" {
+			sec, err := strconv.Atoi(s)
+			if err != nil || sec <= 0 {
+				return nil, errors.New("CIRCUIT_BREAKER_COOLDOWN_SECONDS must be seconds")
+			}
+			conf.breakerCooldown = time.Duration(sec) * time.Second
+		}
+	}
+
+	conf.httpTimeout = defaultHTTPTimeout
+	if s := getenv("HTTP_TIMEOUT_SECONDS"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec <= 0 {
+			return nil, errors.New("HTTP_TIMEOUT_SECONDS must be seconds")
+		}
+		conf.httpTimeout = time.Duration(sec) * time.Second
+	}
+
+	if conf.verifyDelivery = getenv("VERIFY_DELIVERY") != ""; conf.verifyDelivery {
+		// verified later by the delayed message
+		if getenv("RETRY_QUEUE_URL") == "" && conf.outputMode != outputStdout {
+			return nil, errors.New("VERIFY_DELIVERY requires RETRY_QUEUE_URL")
+		}
+		conf.verifyDelay = defaultVerifyDelay
+		if s := getenv("VERIFY_DELAY_SECONDS"); s != "" {
+			sec, err := strconv.Atoi(s)
+			if err != nil || sec < 0 || sec > 900 {
+				return nil, errors.New("VERIFY_DELAY_SECONDS must be seconds up to 900")
+			}
+			conf.verifyDelay = time.Duration(sec) * time.Second
+		}
+	}
+
+	conf.flushReserve = defaultFlushReserve
+	if s := getenv("FLUSH_RESERVE_SECONDS"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec < 0 {
+			return nil, errors.New("FLUSH_RESERVE_SECONDS must be seconds")
+		}
+		conf.flushReserve = time.Duration(sec) * time.Second
+	}
+
+	if s := getenv("PROXY_URL"); s != "" {
+		u, err := url.Parse(s)
+		if err != nil || u.Host == "" {
+			return nil, fmt.Errorf("PROXY_URL must be a URL like http://proxy.example.com:3128")
+		}
+		conf.proxyURL = u
+		conf.noProxy = getenv("NO_PROXY")
+	}
+	conf.httpClient = http.DefaultClient
+	if conf.proxyURL != nil {
+		conf.httpClient = newProxyClient(conf.proxyURL, conf.noProxy)
+	}
+
+	// resolved from MACKEREL_APIKEY_PARAMETER or MACKEREL_APIKEY_ENCRYPTED by parseEnvVars
+	conf.apiKey = getenv("MACKEREL_APIKEY")
+	// not required to print the reports
+	if conf.apiKey == "" && conf.outputMode != outputStdout {
+		return nil, errors.New("MACKEREL_APIKEY, MACKEREL_APIKEY_PARAMETER or MACKEREL_APIKEY_ENCRYPTED is required")
+	}
+
+	if s := getenv("MACKEREL_APIKEYS"); s != "" {
+		creds, err := parseCredentials(s)
+		if err != nil {
+			return nil, fmt.Errorf("MACKEREL_APIKEYS is invalid: %s", err)
+		}
+		conf.credentials = creds
+	}
+
+	switch conf.validateAPIKeys = getenv("VALIDATE_APIKEYS"); conf.validateAPIKeys {
+	case "", validateWarn, validateFail:
+	default:
+		return nil, fmt.Errorf("VALIDATE_APIKEYS must be %q or %q", validateWarn, validateFail)
+	}
+
+	if s := getenv("GROUP_PATTERN"); s != "" {
+		re, err := regexp.Compile(s)
+		if err != nil {
+			return nil, fmt.Errorf("GROUP_PATTERN is invalid: %s", err)
+		}
+		if re.NumSubexp() < 1 {
+			return nil, errors.New("GROUP_PATTERN must have a capture group")
+		}
+		conf.groupPattern = re
+	}
+	conf.groupDimension = getenv("GROUP_DIMENSION")
+	conf.groupStateTable = getenv("GROUP_STATE_TABLE")
+	if conf.grouping() && conf.groupStateTable == "" {
+		return nil, errors.New("GROUP_STATE_TABLE is required to group alarms")
+	}
+
+	if s := getenv("MESSAGE_TEMPLATE"); s != "" {
+		tmpl, err := parseMessageTemplate(s)
+		if err != nil {
+			return nil, fmt.Errorf("MESSAGE_TEMPLATE is invalid: %s", err)
+		}
+		conf.messageTemplate = tmpl
+	}
+
+	if s := getenv("MESSAGE_FIELDS"); s != "" {
+		fields, err := parseMessageFields(s)
+		if err != nil {
+			return nil, fmt.Errorf("MESSAGE_FIELDS is invalid: %s", err)
+		}
+		conf.messageFields = fields
+	}
+
+	conf.consoleLink = getenv("CONSOLE_LINK") != ""
+	conf.stateTransition = getenv("STATE_TRANSITION") != ""
+	if conf.runbookTag = getenv("RUNBOOK_TAG"); conf.runbookTag != "" {
+		conf.runbookTags = newAlarmTags()
+	}
+
+	switch conf.occurredAtSource = getenv("OCCURRED_AT"); conf.occurredAtSource {
+	case "", occurredAtNow, occurredAtStateChangeTime, occurredAtPublished:
+	default:
+		return nil, fmt.Errorf("OCCURRED_AT must be %q, %q or %q", occurredAtNow, occurredAtStateChangeTime, occurredAtPublished)
+	}
+
+	if s := getenv("TIMEZONE"); s != "" {
+		loc, err := time.LoadLocation(s)
+		if err != nil {
+			return nil, fmt.Errorf("TIMEZONE is invalid: %s", err)
+		}
+		conf.timeLocation = loc
+	}
+	conf.timeFormat = getenv("TIME_FORMAT")
+
+	switch conf.messageTruncation = getenv("MESSAGE_TRUNCATION"); conf.messageTruncation {
+	case "", truncateHead, truncateHeadTail, truncateFields:
+	default:
+		return nil, fmt.Errorf("MESSAGE_TRUNCATION must be %q, %q or %q", truncateHead, truncateHeadTail, truncateFields)
+	}
+
+	if s := getenv("CHECK_NAME_TEMPLATE"); s != "" {
+		tmpl, err := template.New("name").Funcs(messageFuncs).Parse(s)
+		if err != nil {
+			return nil, fmt.Errorf("CHECK_NAME_TEMPLATE is invalid: %s", err)
+		}
+		conf.nameTemplate = tmpl
+	}
+
+	if s := getenv("NAME_REWRITE_RULES"); s != "" {
+		rules, err := parseRewriteRules(s)
+		if err != nil {
+			return nil, fmt.Errorf("NAME_REWRITE_RULES is invalid: %s", err)
+		}
+		conf.rewriteRules = rules
+	}
+	if s := getenv("ENV_PREFIX"); s != "" {
+		rule, err := prefixRule(s)
+		if err != nil {
+			return nil, fmt.Errorf("ENV_PREFIX is invalid: %s", err)
+		}
+		conf.rewriteRules = append(conf.rewriteRules, rule)
+	}
+
+	if s := getenv("STATUS_MAPPINGS"); s != "" {
+		mappings, err := parseStatusMappings(s)
+		if err != nil {
+			return nil, fmt.Errorf("STATUS_MAPPINGS is invalid: %s", err)
+		}
+		conf.statusMappings = mappings
+	}
+
+	switch conf.unknownAction = getenv("UNKNOWN_MESSAGE_ACTION"); conf.unknownAction {
+	case "", unknownSkip, unknownFail, unknownReport:
+	default:
+		return nil, fmt.Errorf("UNKNOWN_MESSAGE_ACTION must be %q, %q or %q", unknownSkip, unknownFail, unknownReport)
+	}
+
+	switch conf.missingData = getenv("MISSING_DATA_ACTION"); conf.missingData {
+	case "", missingDataUnknown, missingDataSkip:
+	default:
+		return nil, fmt.Errorf("MISSING_DATA_ACTION must be %q or %q", missingDataUnknown, missingDataSkip)
+	}
+
+	if s := getenv("REASON_RULES"); s != "" {
+		rules, err := parseReasonRules(s)
+		if err != nil {
+			return nil, fmt.Errorf("REASON_RULES is invalid: %s", err)
+		}
+		conf.reasonRules = rules
+	}
+
+	if s := getenv("NOTIFICATION_INTERVALS"); s != "" {
+		rules, err := parseIntervalRules(s)
+		if err != nil {
+			return nil, fmt.Errorf("NOTIFICATION_INTERVALS is invalid: %s", err)
+		}
+		conf.intervalRules = rules
+	}
+	if s := getenv("NOTIFICATION_INTERVAL"); s != "" {
+		min, err := strconv.Atoi(s)
+		if err != nil || min < minNotificationInterval {
+			return nil, fmt.Errorf("NOTIFICATION_INTERVAL must be minutes, %d or more", minNotificationInterval)
+		}
+		conf.defaultInterval = min
+	}
+
+	cacheTTL := defaultHostCacheTTL
+	if s := getenv("HOST_CACHE_TTL"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec < 0 {
+			return nil, errors.New("HOST_CACHE_TTL must be seconds")
+		}
+		cacheTTL = time.Duration(sec) * time.Second
+	}
+	conf.hostCache = newHostCache(cacheTTL, getenv("HOST_CACHE_TABLE"))
+
+	if s := getenv("HOST_ROUTES"); s != "" {
+		routes, err := parseHostRoutes(s, conf.credentials)
+		if err != nil {
+			return nil, fmt.Errorf("HOST_ROUTES is invalid: %s", err)
+		}
+		conf.resolvers = append(conf.resolvers, routes)
+	}
+
+	if s := getenv("DESTINATION_RULES"); s != "" {
+		rules, err := parseDestinationRules(s, conf.credentials)
+		if err != nil {
+			return nil, fmt.Errorf("DESTINATION_RULES is invalid: %s", err)
+		}
+		conf.resolvers = append(conf.resolvers, rules)
+		conf.notifiers = append(conf.notifiers, notifier{name: "destinations", Notifier: rules})
+	}
+
+	if s := getenv("HOST_MAP_TABLE"); s != "" {
+		conf.resolvers = append(conf.resolvers, hostSources{newHostMapTable(s, conf.hostCache)})
+	}
+
+	if getenv("AWS_INTEGRATION_HOSTS") != "" {
+		conf.resolvers = append(conf.resolvers, hostSources{&awsIntegrationResolver{
+			client: conf.newClient(conf.apiKey),
+			cache:  conf.hostCache,
+		}})
+	}
+
+	hostNameTemplate := getenv("HOST_NAME_TEMPLATE")
+	if s := getenv("HOST_NAME_DIMENSION"); s != "" && hostNameTemplate == "" {
+		hostNameTemplate = fmt.Sprintf("{{ .Trigger.Dimension %q }}", s)
+	}
+	if hostNameTemplate != "" {
+		r, err := newHostNameResolver(hostNameTemplate, conf.newClient(conf.apiKey), conf.hostCache)
+		if err != nil {
+			return nil, fmt.Errorf("HOST_NAME_TEMPLATE is invalid: %s", err)
+		}
+		r.create = getenv("CREATE_MISSING_HOSTS") != ""
+		if s := getenv("CREATE_HOST_ROLES"); s != "" {
+			for _, role := range strings.Split(s, ",") {
+				if !strings.Contains(role, ":") {
+					return nil, fmt.Errorf("CREATE_HOST_ROLES must be like service:role, but got %q", role)
+				}
+				r.roles = append(r.roles, role)
+			}
+		}
+		conf.resolvers = append(conf.resolvers, hostSources{r})
+	}
+
+	conf.stateTable = getenv("STATE_TABLE")
+	if s := getenv("STALE_HOURS"); s != "" {
+		hours, err := strconv.Atoi(s)
+		if err != nil || hours <= 0 {
+			return nil, errors.New("STALE_HOURS must be a positive integer")
+		}
+		if conf.stateTable == "" {
+			return nil, errors.New("STATE_TABLE is required to use STALE_HOURS")
+		}
+		conf.staleAfter = time.Duration(hours) * time.Hour
+	}
+
+	conf.digestTopic = getenv("DIGEST_TOPIC_ARN")
+	if conf.digestSlackURL = getenv("DIGEST_SLACK_WEBHOOK_URL"); conf.digestSlackURL == "" {
+		conf.digestSlackURL = getenv("SLACK_WEBHOOK_URL")
+	}
+
+	if conf.dashboardID = getenv("DASHBOARD_ID"); conf.dashboardID != "" {
+		if conf.stateTable == "" {
+			return nil, errors.New("STATE_TABLE is required to use DASHBOARD_ID")
+		}
+		if conf.dashboardWidgetTitle = getenv("DASHBOARD_WIDGET_TITLE"); conf.dashboardWidgetTitle == "" {
+			conf.dashboardWidgetTitle = defaultDashboardWidgetTitle
+		}
+	}
+
+	if conf.snapshotBucket = getenv("SNAPSHOT_BUCKET"); conf.snapshotBucket != "" {
+		if conf.stateTable == "" {
+			return nil, errors.New("STATE_TABLE is required to use SNAPSHOT_BUCKET")
+		}
+		if conf.snapshotKey = getenv("SNAPSHOT_KEY"); conf.snapshotKey == "" {
+			conf.snapshotKey = defaultSnapshotKey
+		}
+	}
+
+	if getenv("RESOURCE_TAGS") != "" {
+		conf.resourceTags = newResourceTags()
+		if conf.serviceTag = getenv("SERVICE_TAG"); conf.serviceTag == "" {
+			conf.serviceTag = "Service"
+		}
+		if conf.roleTag = getenv("ROLE_TAG"); conf.roleTag == "" {
+			conf.roleTag = "Role"
+		}
+	}
+
+	conf.fallbackHostID = getenv("FALLBACK_HOST_ID")
+	conf.fallbackService = getenv("FALLBACK_SERVICE")
+	if s := getenv("FALLBACK_ROLES"); s != "" {
+		conf.fallbackRoles = strings.Split(s, ",")
+	}
+	switch conf.fallbackMode = getenv("FALLBACK_MODE"); conf.fallbackMode {
+	case "", fallbackAnnotation, fallbackMetric:
+	default:
+		return nil, fmt.Errorf("FALLBACK_MODE must be %q or %q", fallbackAnnotation, fallbackMetric)
+	}
+
+	if s := getenv("MIRROR_ORGS"); s != "" {
+		orgs, err := parseMirrorOrgs(s, conf.credentials)
+		if err != nil {
+			return nil, fmt.Errorf("MIRROR_ORGS is invalid: %s", err)
+		}
+		conf.mirrorOrgs = orgs
+	}
+
+	conf.deadLetterBucket = getenv("DEAD_LETTER_BUCKET")
+	conf.deadLetterPrefix = getenv("DEAD_LETTER_PREFIX")
+	conf.deadLetterQueueURL = getenv("DEAD_LETTER_QUEUE_URL")
+
+	if conf.idempotencyTable = getenv("IDEMPOTENCY_TABLE"); conf.idempotencyTable != "" {
+		conf.idempotencyTTL = defaultIdempotencyTTL
+		if s := getenv("IDEMPOTENCY_TTL_SECONDS"); s != "" {
+			sec, err := strconv.Atoi(s)
+			if err != nil || sec <= 0 {
+				return nil, errors.New("IDEMPOTENCY_TTL_SECONDS must be seconds")
+			}
+			conf.idempotencyTTL = time.Duration(sec) * time.Second
+		}
+	}
+	switch conf.idempotencyKey = getenv("IDEMPOTENCY_KEY"); conf.idempotencyKey {
+	case "", idempotencyMessageID, idempotencyAlarm:
+	default:
+		return nil, fmt.Errorf("IDEMPOTENCY_KEY must be %q or %q", idempotencyMessageID, idempotencyAlarm)
+	}
+
+	if conf.retryQueueURL = getenv("RETRY_QUEUE_URL"); conf.retryQueueURL != "" {
+		conf.retryDelay = defaultRetryDelay
+		if s := getenv("RETRY_DELAY_SECONDS"); s != "" {
+			sec, err := strconv.ParseInt(s, 10, 64)
+			if err != nil || sec < 0 || sec > 900 {
+				return nil, errors.New("RETRY_DELAY_SECONDS must be seconds up to 900")
+			}
+			conf.retryDelay = sec
+		}
+		conf.retryMaxAttempts = defaultRetryMaxAttempts
+		if s := getenv("RETRY_MAX_ATTEMPTS"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, errors.New("RETRY_MAX_ATTEMPTS must be a positive integer")
+			}
+			conf.retryMaxAttempts = n
+		}
+		conf.retryBatchItemFailures = getenv("RETRY_QUEUE_BATCH_ITEM_FAILURES") != ""
+	}
+
+	if conf.opsTopic = getenv("OPS_TOPIC_ARN"); conf.opsTopic != "" {
+		conf.opsAlertFailures = defaultOpsAlertFailures
+		if s := getenv("OPS_ALERT_FAILURES"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, errors.New("OPS_ALERT_FAILURES must be a positive integer")
+			}
+			conf.opsAlertFailures = n
+		}
+	}
+
+	if conf.outboxTable = getenv("OUTBOX_TABLE"); conf.outboxTable != "" {
+		conf.outboxMaxAttempts = defaultOutboxMaxAttempts
+		if s := getenv("OUTBOX_MAX_ATTEMPTS"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n <= 0 {
+				return nil, errors.New("OUTBOX_MAX_ATTEMPTS must be a positive integer")
+			}
+			conf.outboxMaxAttempts = n
+		}
+	}
+
+	conf.bufferQueueURL = getenv("BUFFER_QUEUE_URL")
+	switch conf.bufferMode = getenv("BUFFER_MODE"); conf.bufferMode {
+	case "":
+	case bufferReceiver, bufferPoster:
+		if conf.bufferQueueURL == "" {
+			return nil, fmt.Errorf("BUFFER_MODE=%s requires BUFFER_QUEUE_URL", conf.bufferMode)
+		}
+	default:
+		return nil, fmt.Errorf("BUFFER_MODE must be %q or %q", bufferReceiver, bufferPoster)
+	}
+	conf.bufferBatchItemFailures = getenv("BUFFER_BATCH_ITEM_FAILURES") != ""
+	if s := getenv("POST_RATE_LIMIT"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			return nil, errors.New("POST_RATE_LIMIT must be a positive integer")
+		}
+		conf.postRateLimit = n
+	}
+
+	switch conf.deliveryMode = getenv("DELIVERY_MODE"); conf.deliveryMode {
+	case "", atLeastOnce:
+	case exactlyOnce:
+		if conf.idempotencyTable == "" || conf.retryQueueURL == "" {
+			return nil, errors.New("DELIVERY_MODE=exactly_once requires IDEMPOTENCY_TABLE and RETRY_QUEUE_URL")
+		}
+	default:
+		return nil, fmt.Errorf("DELIVERY_MODE must be %q or %q", atLeastOnce, exactlyOnce)
+	}
+
+	conf.auditTable = getenv("AUDIT_TABLE")
+	conf.auditBucket = getenv("AUDIT_BUCKET")
+	conf.auditPrefix = getenv("AUDIT_PREFIX")
+	if conf.auditTable != "" && conf.auditBucket != "" {
+		return nil, errors.New("AUDIT_TABLE and AUDIT_BUCKET are exclusive")
+	}
+
+	asyncInvoke, err := parseAsyncInvokeConfig(getenv)
+	if err != nil {
+		return nil, err
+	}
+	conf.asyncInvoke = asyncInvoke
+
+	conf.selfMetricsNamespace = getenv("SELF_METRICS_NAMESPACE")
+	conf.republishTopic = getenv("REPUBLISH_TOPIC_ARN")
+	conf.firehoseStream = getenv("FIREHOSE_STREAM")
+
+	if s := getenv("WEBHOOKS"); s != "" {
+		hooks, err := parseWebhooks(s)
+		if err != nil {
+			return nil, fmt.Errorf("WEBHOOKS is invalid: %s", err)
+		}
+		for _, h := range hooks {
+			conf.notifiers = append(conf.notifiers, notifier{name: h.URL, Notifier: h})
+		}
+	}
+
+	if s := getenv("TEAMS_WEBHOOK_URL"); s != "" {
+		conf.notifiers = append(conf.notifiers, notifier{name: "teams", Notifier: &teamsNotifier{url: s}})
+	}
+
+	if s := getenv("DATADOG_API_KEY"); s != "" {
+		conf.notifiers = append(conf.notifiers, notifier{
+			name:     "datadog",
+			Notifier: newDatadogNotifier(s, getenv("DATADOG_SITE")),
+		})
+	}
+
+	if s := getenv("OPSGENIE_API_KEY"); s != "" {
+		conf.notifiers = append(conf.notifiers, notifier{
+			name:     "opsgenie",
+			Notifier: newOpsgenieNotifier(s, getenv("OPSGENIE_API_URL")),
+		})
+	}
+
+	if s := getenv("PAGERDUTY_ROUTING_KEY"); s != "" {
+		conf.notifiers = append(conf.notifiers, notifier{
+			name:        "pagerduty",
+			Notifier:    &pagerDutyFallback{routingKey: s},
+			onlyFailure: true,
+		})
+	}
+
+	if s := getenv("SLACK_WEBHOOK_URL"); s != "" {
+		var onlyFailure bool
+		switch mode := getenv("SLACK_MODE"); mode {
+		case "", "all":
+		case "failure":
+			onlyFailure = true
+		default:
+			return nil, fmt.Errorf("SLACK_MODE must be %q or %q", "all", "failure")
+		}
+		slack, err := newSlackNotifier(s, getenv("SLACK_TEMPLATE"))
+		if err != nil {
+			return nil, fmt.Errorf("SLACK_TEMPLATE is invalid: %s", err)
+		}
+		conf.notifiers = append(conf.notifiers, notifier{name: "slack", Notifier: slack, onlyFailure: onlyFailure})
+	}
+
+	conf.stateMetricService = getenv("STATE_METRIC_SERVICE")
+	conf.hostStateMetrics = getenv("HOST_STATE_METRICS") != ""
+
+	conf.annotationService = getenv("ANNOTATION_SERVICE")
+	if s := getenv("ANNOTATION_ROLES"); s != "" {
+		conf.annotationRoles = strings.Split(s, ",")
+	}
+	conf.annotationOnly = getenv("ANNOTATION_ONLY") != ""
+	if conf.annotationOnly && conf.annotationService == "" {
+		return nil, errors.New("ANNOTATION_SERVICE is required to use ANNOTATION_ONLY")
+	}
+
+	switch conf.retiredAction = getenv("RETIRED_HOST_ACTION"); conf.retiredAction {
+	case "", retiredSkip, retiredFallback:
+	default:
+		return nil, fmt.Errorf("RETIRED_HOST_ACTION must be %q or %q", retiredSkip, retiredFallback)
+	}
+
+	conf.closeAlerts = getenv("CLOSE_ALERTS_ON_OK") != ""
+
+	if pattern, tag := getenv("MAINTENANCE_ALARMS"), getenv("MAINTENANCE_TAG"); pattern != "" || tag != "" {
+		m := &maintenance{tag: tag, duration: defaultMaintenanceMinutes * time.Minute}
+		if pattern != "" {
+			re, err := compileGlob(pattern)
+			if err != nil {
+				return nil, fmt.Errorf("MAINTENANCE_ALARMS is invalid: %s", err)
+			}
+			m.alarms = re
+		}
+		if tag != "" {
+			m.tags = newAlarmTags()
+		}
+		if s := getenv("MAINTENANCE_MINUTES"); s != "" {
+			min, err := strconv.Atoi(s)
+			if err != nil || min <= 0 {
+				return nil, errors.New("MAINTENANCE_MINUTES must be a positive integer")
+			}
+			m.duration = time.Duration(min) * time.Minute
+		}
+		conf.maintenance = m
+	}
+
+	switch conf.downtimeAction = getenv("DOWNTIME_ACTION"); conf.downtimeAction {
+	case "", downtimeSkip, downtimeDowngrade:
+	default:
+		return nil, fmt.Errorf("DOWNTIME_ACTION must be %q or %q", downtimeSkip, downtimeDowngrade)
+	}
+
+	if getenv("STRICT_CONFIG") != "" {
+		if err := conf.validate(getenv); err != nil {
+			return nil, fmt.Errorf("STRICT_CONFIG: %s", err)
+		}
+	}
+
+	return conf, nil
+}
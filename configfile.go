@@ -0,0 +1,122 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// configFile is the settings in CONFIG_FILE, keyed by the names of the environment variables.
+// the structured values like HOST_ROUTES can be written in YAML, instead of JSON strings.
+//
+//	HOST_ID: xxxxxxxx
+//	HOST_ROUTES:
+//	  - namespace: AWS/RDS
+//	    host_id: databases
+//	SLACK_WEBHOOK_URL: https://hooks.slack.com/services/...
+type configFile map[string]string
+
+// readConfigFile reads the YAML (or JSON) file in the deployment package,
+// in S3 by "s3://bucket/key", or in SSM parameter store by "ssm:<parameter name>".
+// AWS AppConfig and DynamoDB are read by configSource instead.
+func readConfigFile(ctx context.Context, path string) ([]byte, error) {
+	switch {
+	case strings.HasPrefix(path, "s3://"):
+		return readS3(ctx, path)
+	case strings.HasPrefix(path, "ssm:"):
+		v, err := getParameter(ctx, strings.TrimPrefix(path, "ssm:"))
+		return []byte(v), err
+	default:
+		return ioutil.ReadFile(path)
+	}
+}
+
+func readS3(ctx context.Context, s3url string) ([]byte, error) {
+	u, err := url.Parse(s3url)
+	if err != nil {
+		return nil, err
+	}
+	out, err := s3.New(awsSession()).GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(u.Host),
+		Key:    aws.String(strings.TrimPrefix(u.Path, "/")),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer out.Body.Close()
+	return ioutil.ReadAll(out.Body)
+}
+
+func parseConfigFile(b []byte) (configFile, error) {
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(b, &raw); err != nil {
+		return nil, err
+	}
+	// the disabled flags and the nulls fall back to the defaults
+	for name, v := range raw {
+		if v = unwrapFeatureFlag(v); v == nil {
+			delete(raw, name)
+			continue
+		}
+		raw[name] = v
+	}
+	if err := validateConfigFile(raw, b); err != nil {
+		return nil, err
+	}
+	file := make(configFile, len(raw))
+	for name, v := range raw {
+		s, err := settingString(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", name, err)
+		}
+		file[strings.ToUpper(name)] = s
+	}
+	return file, nil
+}
+
+// settingString converts the value to the string of the environment variable, JSON for lists and maps.
+func settingString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		// the flags are set by any non-empty string
+		if !v {
+			return "", nil
+		}
+		return "true", nil
+	case []interface{}, map[interface{}]interface{}, map[string]interface{}:
+		b, err := json.Marshal(jsonCompatible(v))
+		return string(b), err
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// jsonCompatible converts the maps decoded by yaml.v2 to be encoded as JSON.
+func jsonCompatible(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = jsonCompatible(e)
+		}
+		return m
+	case []interface{}:
+		for i, e := range v {
+			v[i] = jsonCompatible(e)
+		}
+		return v
+	default:
+		return v
+	}
+}
@@ -0,0 +1,38 @@
+package cwa2mkr
+
+import "testing"
+
+func TestParseConfigFileFeatureFlags(t *testing.T) {
+	tests := []struct {
+		name  string
+		yaml  string
+		value string
+		set   bool
+	}{
+		{"disabled flag", "CLOSE_ALERTS_ON_OK:\n  enabled: false\n", "", false},
+		{"disabled flag with value", "ENV_PREFIX:\n  enabled: false\n  value: \"[prod] \"\n", "", false},
+		{"enabled flag", "CLOSE_ALERTS_ON_OK:\n  enabled: true\n", "true", true},
+		{"enabled flag with value", "STALE_HOURS:\n  enabled: true\n  value: 12\n", "12", true},
+		{"null", "ENV_PREFIX:\n", "", false},
+		{"plain value", "ENV_PREFIX: \"[dev] \"\n", "[dev] ", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file, err := parseConfigFile([]byte(tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(file) > 1 {
+				t.Fatalf("got %v", file)
+			}
+			for _, v := range file {
+				if v != tt.value {
+					t.Errorf("value = %q, want %q", v, tt.value)
+				}
+			}
+			if set := len(file) == 1; set != tt.set {
+				t.Errorf("set = %v, want %v", set, tt.set)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package cwa2mkr
+
+import (
+	"fmt"
+	"net/url"
+)
+
+// consoleURL returns the URL of the alarm in the CloudWatch console, or empty if the region is unknown.
+func (m Alarm) consoleURL() string {
+	region, _ := alarmRegionAccount(m.AlarmArn)
+	if region == "" {
+		return ""
+	}
+	return fmt.Sprintf("https://%s.console.aws.amazon.com/cloudwatch/home?region=%s#alarmsV2:alarm/%s", region, region, url.PathEscape(m.AlarmName))
+}
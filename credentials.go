@@ -0,0 +1,41 @@
+package cwa2mkr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// credentials are the API keys of the organizations named by MACKEREL_APIKEYS,
+// selected by "credential" of HOST_ROUTES, DESTINATION_RULES and MIRROR_ORGS instead of "api_key".
+//
+//	{"prod": "xxx", "dev": "yyy"}
+type credentials map[string]string
+
+func parseCredentials(s string) (credentials, error) {
+	var c credentials
+	if err := json.Unmarshal([]byte(s), &c); err != nil {
+		return nil, err
+	}
+	for name, key := range c {
+		if key == "" {
+			return nil, fmt.Errorf("the API key of %q is empty", name)
+		}
+	}
+	return c, nil
+}
+
+// apiKey returns the API key of a rule, by api_key or the named credential.
+func (c credentials) apiKey(apiKey, name string) (string, error) {
+	if name == "" {
+		return apiKey, nil
+	}
+	if apiKey != "" {
+		return "", errors.New("api_key and credential are exclusive")
+	}
+	key, ok := c[name]
+	if !ok {
+		return "", fmt.Errorf("credential %q is not in MACKEREL_APIKEYS", name)
+	}
+	return key, nil
+}
@@ -0,0 +1,92 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+const defaultDashboardWidgetTitle = "CloudWatch alarms"
+
+// updateDashboard rewrites the markdown widget of DASHBOARD_ID with the checks not OK in STATE_TABLE.
+// the widget is added at the bottom if the dashboard has no widget of DASHBOARD_WIDGET_TITLE.
+func (f *forwarder) updateDashboard(ctx context.Context, states []checkState) error {
+	markdown := alarmBoard(states)
+
+	dashboard, err := f.client.getDashboard(ctx, f.conf.dashboardID)
+	if err != nil {
+		return err
+	}
+	widgets, _ := dashboard["widgets"].([]interface{})
+
+	found := false
+	bottom := 0.0
+	for _, w := range widgets {
+		widget, ok := w.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if layout, ok := widget["layout"].(map[string]interface{}); ok {
+			y, _ := layout["y"].(float64)
+			h, _ := layout["height"].(float64)
+			if y+h > bottom {
+				bottom = y + h
+			}
+		}
+		if widget["type"] == "markdown" && widget["title"] == f.conf.dashboardWidgetTitle {
+			widget["markdown"] = markdown
+			found = true
+		}
+	}
+	if !found {
+		widgets = append(widgets, map[string]interface{}{
+			"type":     "markdown",
+			"title":    f.conf.dashboardWidgetTitle,
+			"markdown": markdown,
+			"layout":   map[string]interface{}{"x": 0, "y": bottom, "width": 24, "height": 8},
+		})
+	}
+
+	err = f.client.updateDashboard(ctx, f.conf.dashboardID, map[string]interface{}{
+		"title":   dashboard["title"],
+		"memo":    dashboard["memo"],
+		"urlPath": dashboard["urlPath"],
+		"widgets": widgets,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("updated the dashboard %s", f.conf.dashboardID)
+	return nil
+}
+
+// alarmBoard renders the checks not OK as a markdown table, the worst first.
+func alarmBoard(states []checkState) string {
+	var alarms []checkState
+	for _, st := range states {
+		if st.Status != StatusOK {
+			alarms = append(alarms, st)
+		}
+	}
+	if len(alarms) == 0 {
+		return "All alarms are OK."
+	}
+	sort.Slice(alarms, func(i, j int) bool {
+		if a, b := statusSeverity[alarms[i].Status], statusSeverity[alarms[j].Status]; a != b {
+			return a > b
+		}
+		return alarms[i].UpdatedAt.After(alarms[j].UpdatedAt)
+	})
+
+	var b strings.Builder
+	b.WriteString("| Status | Check | Host | Updated at |\n")
+	b.WriteString("| ------ | ----- | ---- | ---------- |\n")
+	for _, st := range alarms {
+		fmt.Fprintf(&b, "| %s | %s | [%s](https://mackerel.io/orgs/-/hosts/%s) | %s |\n",
+			st.Status, strings.Replace(st.Name, "|", `\|`, -1), st.HostID, st.HostID, st.UpdatedAt.UTC().Format(time.RFC3339))
+	}
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package cwa2mkr
+
+import (
+	"context"
+	"net/http"
+)
+
+const defaultDatadogSite = "datadoghq.com"
+
+// datadogAlertTypes maps the mackerel status to the alert type of Datadog events.
+var datadogAlertTypes = map[string]string{
+	StatusOK:       "success",
+	StatusWarning:  "warning",
+	StatusCritical: "error",
+	StatusUnknown:  "info",
+}
+
+// datadogNotifier posts the reports as Datadog events, to dual-write during the migration of the monitoring platform.
+// https://docs.datadoghq.com/api/latest/events/#post-an-event
+type datadogNotifier struct {
+	url    string
+	header http.Header
+}
+
+func newDatadogNotifier(apiKey, site string) *datadogNotifier {
+	if site == "" {
+		site = defaultDatadogSite
+	}
+	header := make(http.Header)
+	header.Set("DD-API-KEY", apiKey)
+	return &datadogNotifier{
+		url:    "https://api." + site + "/api/v1/events",
+		header: header,
+	}
+}
+
+func (d *datadogNotifier) Notify(ctx context.Context, rep Report) error {
+	tags := []string{"source:cloudwatch-alarm-to-mackerel", "check:" + rep.Name}
+	if rep.Source.HostID != "" {
+		tags = append(tags, "mackerel_host_id:"+rep.Source.HostID)
+	}
+	if rep.alarm != nil && rep.alarm.Trigger.Namespace != "" {
+		tags = append(tags, "namespace:"+rep.alarm.Trigger.Namespace)
+	}
+	return postJSON(ctx, d.url, map[string]interface{}{
+		"title":           rep.Status + " " + rep.Name,
+		"text":            rep.Message,
+		"alert_type":      datadogAlertTypes[rep.Status],
+		"aggregation_key": rep.Name,
+		"date_happened":   rep.OccurredAt,
+		"tags":            tags,
+	}, d.header)
+}
@@ -0,0 +1,137 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+// deadLetter is written to DEAD_LETTER_BUCKET or DEAD_LETTER_QUEUE_URL when posting reports failed, to be replayed later.
+type deadLetter struct {
+	Error string `json:"error"`
+
+	// the number of tries to post
+	Attempts int `json:"attempts"`
+
+	Reports Reports         `json:"reports"`
+	Event   json.RawMessage `json:"event"`
+}
+
+type deadLetterWriter interface {
+	put(ctx context.Context, letter deadLetter) error
+}
+
+// s3DeadLetters writes the dead letters to the S3 bucket,
+// with the key like "<prefix>2006/01/02/<unix nano>-<request id>.json".
+type s3DeadLetters struct {
+	s3     *s3.S3
+	bucket string
+	prefix string
+}
+
+func newS3DeadLetters(bucket, prefix string) *s3DeadLetters {
+	return &s3DeadLetters{
+		s3:     s3.New(awsSession()),
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+func (d *s3DeadLetters) put(ctx context.Context, letter deadLetter) error {
+	b, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+
+	key := datedKey(ctx, d.prefix)
+	_, err = d.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(d.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(b),
+		ContentType: aws.String("application/json"),
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("wrote the dead letter to s3://%s/%s", d.bucket, key)
+	return nil
+}
+
+// datedKey returns the S3 object key like "<prefix>2006/01/02/<unix nano>-<request id>.json".
+func datedKey(ctx context.Context, prefix string) string {
+	now := time.Now().UTC()
+	key := fmt.Sprintf("%s%s/%d", prefix, now.Format("2006/01/02"), now.UnixNano())
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		key += "-" + lc.AwsRequestID
+	}
+	return key + ".json"
+}
+
+// sqsDeadLetters sends the dead letters to the SQS queue.
+type sqsDeadLetters struct {
+	sqs      *sqs.SQS
+	queueURL string
+}
+
+func newSQSDeadLetters(queueURL string) *sqsDeadLetters {
+	return &sqsDeadLetters{
+		sqs:      sqs.New(awsSession()),
+		queueURL: queueURL,
+	}
+}
+
+func (d *sqsDeadLetters) put(ctx context.Context, letter deadLetter) error {
+	b, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	out, err := d.sqs.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:    aws.String(d.queueURL),
+		MessageBody: aws.String(string(b)),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"error": {
+				DataType:    aws.String("String"),
+				StringValue: aws.String(letter.Error),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("sent the dead letter to %s: %s", d.queueURL, aws.StringValue(out.MessageId))
+	return nil
+}
+
+// deadLetter saves the reports failed to post. nil is returned if they are saved to all writers,
+// so that the invocation does not fail and retry.
+func (f *forwarder) deadLetter(ctx context.Context, payload json.RawMessage, reps Reports, attempts int, postErr error) error {
+	if len(f.deadLetters) == 0 {
+		return postErr
+	}
+	log.Printf("failed to post the reports: %s", postErr)
+	letter := deadLetter{
+		Error:    postErr.Error(),
+		Attempts: attempts,
+		Reports:  reps,
+		Event:    payload,
+	}
+	saved := true
+	for _, w := range f.deadLetters {
+		if err := w.put(ctx, letter); err != nil {
+			log.Printf("failed to write the dead letter: %s", err)
+			saved = false
+		}
+	}
+	if !saved {
+		return postErr
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// destinationRule sends the selected alarms to the destinations.
+// all matched rules are applied, unlike HOST_ROUTES.
+//
+//	[
+//	  {"namespace": "AWS/RDS", "destinations": [{"host_id": "databases"}, {"slack": "https://hooks.slack.com/services/..."}]},
+//	  {"account": "123456789012", "destinations": [{"host_id": "hostB", "api_key": "xxx"}]},
+//	  {"tag": "Team", "value": "web", "destinations": [{"slack": "https://hooks.slack.com/services/..."}]}
+//	]
+type destinationRule struct {
+	alarmSelector
+
+	Destinations []destination `json:"destinations"`
+}
+
+// destination is a mackerel host (of the organization of api_key or credential), or a Slack incoming webhook.
+type destination struct {
+	HostID     string `json:"host_id"`
+	APIKey     string `json:"api_key"`
+	Credential string `json:"credential"`
+
+	Slack string `json:"slack"`
+	slack *slackNotifier
+}
+
+// destinationRules resolves the mackerel hosts and notifies Slack of the matched rules.
+type destinationRules struct {
+	rules []destinationRule
+	tags  *tagCache
+}
+
+func parseDestinationRules(s string, creds credentials) (*destinationRules, error) {
+	var rules []destinationRule
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, err
+	}
+	r := &destinationRules{rules: rules}
+	for i := range rules {
+		rule := &rules[i]
+		if err := rule.compile(); err != nil {
+			return nil, fmt.Errorf("rule[%d]: %s", i, err)
+		}
+		if len(rule.Destinations) == 0 {
+			return nil, fmt.Errorf("rule[%d]: destinations is required", i)
+		}
+		for j := range rule.Destinations {
+			d := &rule.Destinations[j]
+			switch {
+			case d.HostID != "" && d.Slack == "":
+				var err error
+				if d.APIKey, err = creds.apiKey(d.APIKey, d.Credential); err != nil {
+					return nil, fmt.Errorf("rule[%d]: destination[%d]: %s", i, j, err)
+				}
+			case d.Slack != "" && d.HostID == "":
+				d.slack, _ = newSlackNotifier(d.Slack, "")
+			default:
+				return nil, fmt.Errorf("rule[%d]: destination[%d]: one of host_id or slack is required", i, j)
+			}
+		}
+		if rule.Tag != "" && r.tags == nil {
+			r.tags = newAlarmTags()
+		}
+	}
+	return r, nil
+}
+
+// matched returns the destinations of all rules matched to the alarm.
+func (r *destinationRules) matched(ctx context.Context, msg Alarm) ([]destination, error) {
+	tags := &alarmTags{cache: r.tags}
+	var dests []destination
+	for _, rule := range r.rules {
+		ok, err := rule.match(ctx, msg, tags)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			dests = append(dests, rule.Destinations...)
+		}
+	}
+	return dests, nil
+}
+
+func (r *destinationRules) ResolveSources(ctx context.Context, msg Alarm) ([]Source, error) {
+	dests, err := r.matched(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	return hostDestinations(dests), nil
+}
+
+func hostDestinations(dests []destination) []Source {
+	seen := make(map[Source]bool)
+	var sources []Source
+	for _, d := range dests {
+		if d.HostID == "" {
+			continue
+		}
+		src := Source{Type: "host", HostID: d.HostID, apiKey: d.APIKey}
+		if !seen[src] {
+			seen[src] = true
+			sources = append(sources, src)
+		}
+	}
+	return sources
+}
+
+// Notify posts the report to the Slack destinations of the alarm.
+// the report fanned out to several hosts is posted once for each host, so only the first source is notified.
+func (r *destinationRules) Notify(ctx context.Context, rep Report) error {
+	if rep.alarm == nil {
+		return nil
+	}
+	dests, err := r.matched(ctx, *rep.alarm)
+	if err != nil {
+		return err
+	}
+	if sources := hostDestinations(dests); len(sources) > 1 && sources[0] != rep.Source {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, d := range dests {
+		if d.slack == nil || seen[d.Slack] {
+			continue
+		}
+		seen[d.Slack] = true
+		if err := d.slack.Notify(ctx, rep); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+const digestPeriod = 24 * time.Hour
+
+// digest posts the summary of the last 24 hours in STATE_TABLE to DIGEST_TOPIC_ARN and DIGEST_SLACK_WEBHOOK_URL.
+// it is invoked by an EventBridge schedule rule with the input {"action": "digest"}.
+func (f *forwarder) digest(ctx context.Context) error {
+	if f.states == nil {
+		log.Println("got a digest event, but STATE_TABLE is not set")
+		return nil
+	}
+	states, err := f.states.all(ctx)
+	if err != nil {
+		return err
+	}
+	since := time.Now().Add(-digestPeriod)
+	text := digestText(states, since)
+
+	if f.conf.digestTopic != "" {
+		_, err := sns.New(awsSession()).PublishWithContext(ctx, &sns.PublishInput{
+			TopicArn: aws.String(f.conf.digestTopic),
+			Subject:  aws.String("CloudWatch alarms digest"),
+			Message:  aws.String(text),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if f.conf.digestSlackURL != "" {
+		if err := postJSON(ctx, f.conf.digestSlackURL, map[string]string{"text": text}, nil); err != nil {
+			return err
+		}
+	}
+	log.Printf("posted the digest of %d checks", len(states))
+
+	// drop the transitions summarized, not to grow the items
+	for _, st := range states {
+		var recent []time.Time
+		for _, t := range st.Transitions {
+			if t.After(since) {
+				recent = append(recent, t)
+			}
+		}
+		if len(recent) == len(st.Transitions) {
+			continue
+		}
+		if err := f.states.setTransitions(ctx, st, recent); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func digestText(states []checkState, since time.Time) string {
+	type count struct {
+		name string
+		n    int
+	}
+	counts := make(map[string]int)
+	total := 0
+	var notOK []checkState
+	for _, st := range states {
+		for _, t := range st.Transitions {
+			if t.After(since) {
+				counts[st.Name]++
+				total++
+			}
+		}
+		if st.Status != StatusOK {
+			notOK = append(notOK, st)
+		}
+	}
+	var sorted []count
+	for name, n := range counts {
+		sorted = append(sorted, count{name, n})
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].n != sorted[j].n {
+			return sorted[i].n > sorted[j].n
+		}
+		return sorted[i].name < sorted[j].name
+	})
+	sort.Slice(notOK, func(i, j int) bool {
+		return statusSeverity[notOK[i].Status] > statusSeverity[notOK[j].Status]
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d transitions of %d checks in the last 24 hours, %d checks are not OK\n", total, len(sorted), len(notOK))
+	if len(notOK) > 0 {
+		b.WriteString("\nNot OK:\n")
+		for _, st := range notOK {
+			fmt.Fprintf(&b, "- %s %s (host: %s) since %s\n", st.Status, st.Name, st.HostID, st.UpdatedAt.UTC().Format(time.RFC3339))
+		}
+	}
+	if len(sorted) > 0 {
+		b.WriteString("\nTransitions:\n")
+		for _, c := range sorted {
+			fmt.Fprintf(&b, "- %s: %d\n", c.name, c.n)
+		}
+	}
+	return b.String()
+}
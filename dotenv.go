@@ -0,0 +1,94 @@
+package cwa2mkr
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultEnvFile is read out of lambda, unless ENV_FILE is set.
+const defaultEnvFile = ".env"
+
+// loadEnvFile sets the environment variables from the .env file (or ENV_FILE) out of lambda, to run locally without exporting them.
+// the variables already set are not overridden, and the settings are validated same as CONFIG_FILE.
+//
+//	# comment
+//	MACKEREL_APIKEY=xxx
+//	export HOST_ID=yyy
+//	CONFIG_FILE=./config.local.yaml
+//	HOST_ROUTES='[{"namespace": "AWS/RDS", "host_id": "zzz"}]'
+func loadEnvFile() error {
+	path := os.Getenv("ENV_FILE")
+	if path == "" {
+		path = defaultEnvFile
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && os.Getenv("ENV_FILE") == "" {
+			return nil
+		}
+		return err
+	}
+	vars, err := parseEnvFile(b)
+	if err != nil {
+		return fmt.Errorf("%s is invalid: %s", path, err)
+	}
+
+	settings := make(map[string]interface{})
+	for _, name := range settingNames() {
+		if v, ok := vars[name]; ok {
+			settings[name] = v
+		}
+	}
+	if err := validateConfigFile(settings, b); err != nil {
+		return fmt.Errorf("%s is invalid: %s", path, err)
+	}
+
+	for name, v := range vars {
+		if _, ok := os.LookupEnv(name); !ok {
+			os.Setenv(name, v)
+		}
+	}
+	return nil
+}
+
+func parseEnvFile(b []byte) (map[string]string, error) {
+	vars := make(map[string]string)
+	s := bufio.NewScanner(bytes.NewReader(b))
+	s.Buffer(make([]byte, 64*1024), 1024*1024)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.Index(line, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: must be like NAME=value", n)
+		}
+		name, value := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		switch {
+		case strings.HasPrefix(value, `"`):
+			v, err := strconv.Unquote(value)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", n, err)
+			}
+			value = v
+		case strings.HasPrefix(value, "'"):
+			if len(value) < 2 || !strings.HasSuffix(value, "'") {
+				return nil, fmt.Errorf("line %d: unterminated quote", n)
+			}
+			value = value[1 : len(value)-1]
+		default:
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+		}
+		vars[name] = value
+	}
+	return vars, s.Err()
+}
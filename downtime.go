@@ -0,0 +1,204 @@
+package cwa2mkr
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// actions for the reports of hosts in downtimes
+const (
+	downtimeSkip      = "skip"
+	downtimeDowngrade = "downgrade"
+)
+
+const (
+	downtimesTTL = time.Minute
+	hostRolesTTL = 5 * time.Minute
+)
+
+// https://mackerel.io/api-docs/entry/downtimes
+type downtime struct {
+	ID                   string              `json:"id,omitempty"`
+	Name                 string              `json:"name"`
+	Start                int64               `json:"start"`
+	Duration             int64               `json:"duration"` // minutes
+	Recurrence           *downtimeRecurrence `json:"recurrence,omitempty"`
+	ServiceScopes        []string            `json:"serviceScopes,omitempty"`
+	ServiceExcludeScopes []string            `json:"serviceExcludeScopes,omitempty"`
+	RoleScopes           []string            `json:"roleScopes,omitempty"`
+	RoleExcludeScopes    []string            `json:"roleExcludeScopes,omitempty"`
+	MonitorScopes        []string            `json:"monitorScopes,omitempty"`
+	MonitorExcludeScopes []string            `json:"monitorExcludeScopes,omitempty"`
+}
+
+type downtimeRecurrence struct {
+	Type     string   `json:"type"` // hourly, daily, weekly, monthly, yearly
+	Interval int      `json:"interval"`
+	Weekdays []string `json:"weekdays"`
+	Until    int64    `json:"until"`
+}
+
+// maxOccurrences bounds the iteration of recurring downtimes.
+const maxOccurrences = 100000
+
+// activeAt reports whether the downtime is in effect at t.
+func (d downtime) activeAt(t time.Time) bool {
+	start := time.Unix(d.Start, 0)
+	duration := time.Duration(d.Duration) * time.Minute
+	if d.Recurrence == nil {
+		return !t.Before(start) && t.Before(start.Add(duration))
+	}
+
+	r := d.Recurrence
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	weekdays := make(map[string]bool, len(r.Weekdays))
+	for _, w := range r.Weekdays {
+		weekdays[w] = true
+	}
+
+	for i := 0; i < maxOccurrences; i++ {
+		var occ time.Time
+		ok := true
+		switch r.Type {
+		case "hourly":
+			occ = start.Add(time.Duration(i*interval) * time.Hour)
+		case "daily":
+			occ = start.AddDate(0, 0, i*interval)
+		case "weekly":
+			// step by a day to check each weekday
+			occ = start.AddDate(0, 0, i)
+			wd := occ.Weekday()
+			ok = (i/7)%interval == 0 && (weekdays[wd.String()] || len(weekdays) == 0 && wd == start.Weekday())
+		case "monthly":
+			occ = start.AddDate(0, i*interval, 0)
+		case "yearly":
+			occ = start.AddDate(i*interval, 0, 0)
+		default:
+			return false
+		}
+		if occ.After(t) || (r.Until > 0 && occ.Unix() > r.Until) {
+			return false
+		}
+		if ok && t.Before(occ.Add(duration)) {
+			return true
+		}
+	}
+	return false
+}
+
+// covers reports whether the host having the roles is in the scopes of the downtime.
+// roles is a map of service name to role names.
+func (d downtime) covers(roles map[string][]string) bool {
+	in := func(scopes []string) bool {
+		for _, scope := range scopes {
+			for service, rs := range roles {
+				if scope == service {
+					return true
+				}
+				for _, role := range rs {
+					if scope == service+": "+role {
+						return true
+					}
+				}
+			}
+		}
+		return false
+	}
+	if in(d.ServiceExcludeScopes) || in(d.RoleExcludeScopes) {
+		return false
+	}
+	if len(d.ServiceScopes) == 0 && len(d.RoleScopes) == 0 {
+		// the downtime only of the monitors (by ids) is not of the hosts, and the check monitors are not known by the reports
+		if len(d.MonitorScopes) > 0 {
+			return false
+		}
+		// the downtime is for the whole organization
+		return true
+	}
+	return in(d.ServiceScopes) || in(d.RoleScopes)
+}
+
+// downtimes tells whether hosts are in downtimes, with caching the API responses.
+type downtimes struct {
+	client *mackerelClient
+
+	mu        sync.Mutex
+	list      []downtime
+	fetchedAt time.Time
+	roles     map[string]cachedRoles
+}
+
+type cachedRoles struct {
+	roles     map[string][]string
+	expiresAt time.Time
+}
+
+func newDowntimes(client *mackerelClient) *downtimes {
+	return &downtimes{
+		client: client,
+		roles:  make(map[string]cachedRoles),
+	}
+}
+
+func (d *downtimes) fetch(ctx context.Context) ([]downtime, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.list != nil && time.Since(d.fetchedAt) < downtimesTTL {
+		return d.list, nil
+	}
+
+	list, err := d.client.listDowntimes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	d.list = list
+	d.fetchedAt = time.Now()
+	return d.list, nil
+}
+
+func (d *downtimes) hostRoles(ctx context.Context, hostID string) (map[string][]string, error) {
+	d.mu.Lock()
+	c, ok := d.roles[hostID]
+	d.mu.Unlock()
+	if ok && time.Now().Before(c.expiresAt) {
+		return c.roles, nil
+	}
+
+	host, err := d.client.getHost(ctx, hostID)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	d.roles[hostID] = cachedRoles{roles: host.Roles, expiresAt: time.Now().Add(hostRolesTTL)}
+	d.mu.Unlock()
+	return host.Roles, nil
+}
+
+// active returns the downtime in effect for the host, or nil.
+func (d *downtimes) active(ctx context.Context, hostID string) (*downtime, error) {
+	list, err := d.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	var roles map[string][]string
+	for i, dt := range list {
+		if !dt.activeAt(now) {
+			continue
+		}
+		if roles == nil {
+			if roles, err = d.hostRoles(ctx, hostID); err != nil {
+				return nil, err
+			}
+		}
+		if dt.covers(roles) {
+			return &list[i], nil
+		}
+	}
+	return nil, nil
+}
@@ -0,0 +1,74 @@
+package cwa2mkr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDowntimeActiveAt(t *testing.T) {
+	// a Monday, at noon not to move the weekday by the local time zone
+	start := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC).Local()
+	at := func(days int, d time.Duration) time.Time {
+		return start.AddDate(0, 0, days).Add(d)
+	}
+	tests := []struct {
+		name       string
+		recurrence *downtimeRecurrence
+		t          time.Time
+		active     bool
+	}{
+		{"once", nil, at(0, 30*time.Minute), true},
+		{"once before", nil, at(0, -time.Minute), false},
+		{"once ended", nil, at(0, time.Hour), false},
+		{"hourly", &downtimeRecurrence{Type: "hourly", Interval: 2}, at(0, 2*time.Hour+10*time.Minute), true},
+		{"hourly off the interval", &downtimeRecurrence{Type: "hourly", Interval: 2}, at(0, time.Hour+10*time.Minute), false},
+		{"daily", &downtimeRecurrence{Type: "daily", Interval: 1}, at(3, 30*time.Minute), true},
+		{"daily between", &downtimeRecurrence{Type: "daily", Interval: 1}, at(3, 2*time.Hour), false},
+		{"daily no interval", &downtimeRecurrence{Type: "daily"}, at(3, 30*time.Minute), true},
+		{"daily until", &downtimeRecurrence{Type: "daily", Interval: 1, Until: at(1, time.Hour).Unix()}, at(3, 30*time.Minute), false},
+		{"weekly weekday", &downtimeRecurrence{Type: "weekly", Interval: 1, Weekdays: []string{"Monday", "Wednesday"}}, at(2, 30*time.Minute), true},
+		{"weekly other weekday", &downtimeRecurrence{Type: "weekly", Interval: 1, Weekdays: []string{"Monday", "Wednesday"}}, at(1, 30*time.Minute), false},
+		{"weekly weekday of the start", &downtimeRecurrence{Type: "weekly", Interval: 2}, at(14, 30*time.Minute), true},
+		{"weekly off the interval", &downtimeRecurrence{Type: "weekly", Interval: 2}, at(7, 30*time.Minute), false},
+		{"monthly", &downtimeRecurrence{Type: "monthly", Interval: 1}, start.AddDate(0, 1, 0).Add(30 * time.Minute), true},
+		{"monthly between", &downtimeRecurrence{Type: "monthly", Interval: 1}, at(15, 30*time.Minute), false},
+		{"yearly", &downtimeRecurrence{Type: "yearly", Interval: 1}, start.AddDate(1, 0, 0).Add(30 * time.Minute), true},
+		{"unknown type", &downtimeRecurrence{Type: "secondly", Interval: 1}, at(0, 30*time.Minute), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := downtime{Start: start.Unix(), Duration: 60, Recurrence: tt.recurrence}
+			if got := d.activeAt(tt.t); got != tt.active {
+				t.Errorf("activeAt(%s) = %v, want %v", tt.t, got, tt.active)
+			}
+		})
+	}
+}
+
+func TestDowntimeCovers(t *testing.T) {
+	roles := map[string][]string{"service": {"web", "batch"}}
+	tests := []struct {
+		name     string
+		downtime downtime
+		covers   bool
+	}{
+		{"organization", downtime{}, true},
+		{"service", downtime{ServiceScopes: []string{"service"}}, true},
+		{"other service", downtime{ServiceScopes: []string{"other"}}, false},
+		{"role", downtime{RoleScopes: []string{"service: web"}}, true},
+		{"other role", downtime{RoleScopes: []string{"service: db"}}, false},
+		{"service excluded", downtime{ServiceExcludeScopes: []string{"service"}}, false},
+		{"role excluded", downtime{RoleExcludeScopes: []string{"service: batch"}}, false},
+		{"other role excluded", downtime{RoleExcludeScopes: []string{"service: db"}}, true},
+		{"monitor", downtime{MonitorScopes: []string{"2cSZzK3XfmG"}}, false},
+		{"monitor excluded", downtime{MonitorExcludeScopes: []string{"2cSZzK3XfmG"}}, true},
+		{"monitor and role", downtime{MonitorScopes: []string{"2cSZzK3XfmG"}, RoleScopes: []string{"service: web"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.downtime.covers(roles); got != tt.covers {
+				t.Errorf("covers = %v, want %v", got, tt.covers)
+			}
+		})
+	}
+}
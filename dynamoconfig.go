@@ -0,0 +1,100 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+)
+
+// defaultDynamoConfigReload is CONFIG_RELOAD_SECONDS for DynamoDB, only the version is read in the interval.
+const defaultDynamoConfigReload = 60 * time.Second
+
+// dynamoConfigSource reads CONFIG_FILE like "dynamodb:<table>/<id>" from the item of the table.
+// the attributes of the item (except "id" and "version") are the settings, and the lists and maps are same as the file.
+// the whole item is read again only when the "version" attribute is changed by the tools managing it.
+//
+//	{"id": "default", "version": 3, "HOST_ROUTES": [{"namespace": "AWS/RDS", "host_id": "xxx"}], "RESOURCE_TAGS": true}
+type dynamoConfigSource struct {
+	table string
+	id    string
+	db    *dynamodb.DynamoDB
+
+	mu      sync.Mutex
+	version string
+	content []byte
+}
+
+func newDynamoConfigSource(path string) (*dynamoConfigSource, error) {
+	ids := strings.SplitN(strings.TrimPrefix(path, "dynamodb:"), "/", 2)
+	if len(ids) != 2 || ids[0] == "" || ids[1] == "" {
+		return nil, fmt.Errorf("CONFIG_FILE must be like dynamodb:<table>/<id>, but got %q", path)
+	}
+	return &dynamoConfigSource{table: ids[0], id: ids[1], db: dynamodb.New(awsSession())}, nil
+}
+
+func (s *dynamoConfigSource) read(ctx context.Context) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := map[string]*dynamodb.AttributeValue{"id": {S: aws.String(s.id)}}
+	if s.content != nil {
+		out, err := s.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+			TableName:                aws.String(s.table),
+			Key:                      key,
+			ProjectionExpression:     aws.String("#version"),
+			ExpressionAttributeNames: map[string]*string{"#version": aws.String("version")},
+			ConsistentRead:           aws.Bool(true),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if itemVersion(out.Item) == s.version {
+			return s.content, nil
+		}
+	}
+
+	out, err := s.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(s.table),
+		Key:            key,
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if out.Item == nil {
+		return nil, fmt.Errorf("no item of id %q in %s", s.id, s.table)
+	}
+	version := itemVersion(out.Item)
+	delete(out.Item, "id")
+	delete(out.Item, "version")
+	var settings map[string]interface{}
+	if err := dynamodbattribute.UnmarshalMap(out.Item, &settings); err != nil {
+		return nil, err
+	}
+	// JSON is parsed as YAML by parseConfigFile
+	b, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+	s.version, s.content = version, b
+	return b, nil
+}
+
+// itemVersion returns the "version" attribute of the item, a number or a string.
+func itemVersion(item map[string]*dynamodb.AttributeValue) string {
+	v, ok := item["version"]
+	if !ok {
+		return ""
+	}
+	if v.N != nil {
+		return *v.N
+	}
+	return aws.StringValue(v.S)
+}
@@ -0,0 +1,107 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// invocationStats counts what happened in an invocation, emitted as CloudWatch embedded metric format logs.
+// the methods do nothing on nil, that is SELF_METRICS_NAMESPACE is not set.
+type invocationStats struct {
+	reportsPosted  int64
+	recordsSkipped int64
+	parseErrors    int64
+	apiFailures    int64
+	discrepancies  int64
+}
+
+type statsKey struct{}
+
+func withStats(ctx context.Context) (context.Context, *invocationStats) {
+	s := &invocationStats{}
+	return context.WithValue(ctx, statsKey{}, s), s
+}
+
+func statsFrom(ctx context.Context) *invocationStats {
+	s, _ := ctx.Value(statsKey{}).(*invocationStats)
+	return s
+}
+
+func (s *invocationStats) posted(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.reportsPosted, int64(n))
+	}
+}
+
+func (s *invocationStats) skipped() {
+	if s != nil {
+		atomic.AddInt64(&s.recordsSkipped, 1)
+	}
+}
+
+func (s *invocationStats) parseError() {
+	if s != nil {
+		atomic.AddInt64(&s.parseErrors, 1)
+	}
+}
+
+func (s *invocationStats) apiFailure() {
+	if s != nil {
+		atomic.AddInt64(&s.apiFailures, 1)
+	}
+}
+
+func (s *invocationStats) discrepancy() {
+	if s != nil {
+		atomic.AddInt64(&s.discrepancies, 1)
+	}
+}
+
+// emit writes the metrics to stdout in the embedded metric format.
+// https://docs.aws.amazon.com/AmazonCloudWatch/latest/monitoring/CloudWatch_Embedded_Metric_Format_Specification.html
+func (s *invocationStats) emit(namespace string) {
+	if s == nil {
+		return
+	}
+	values := map[string]int64{
+		"ReportsPosted":         atomic.LoadInt64(&s.reportsPosted),
+		"RecordsSkipped":        atomic.LoadInt64(&s.recordsSkipped),
+		"ParseErrors":           atomic.LoadInt64(&s.parseErrors),
+		"APIFailures":           atomic.LoadInt64(&s.apiFailures),
+		"DeliveryDiscrepancies": atomic.LoadInt64(&s.discrepancies),
+	}
+	metrics := make([]map[string]string, 0, len(values))
+	for _, name := range []string{"ReportsPosted", "RecordsSkipped", "ParseErrors", "APIFailures", "DeliveryDiscrepancies"} {
+		metrics = append(metrics, map[string]string{"Name": name, "Unit": "Count"})
+	}
+
+	doc := map[string]interface{}{
+		"_aws": map[string]interface{}{
+			"Timestamp": time.Now().UnixNano() / int64(time.Millisecond),
+			"CloudWatchMetrics": []interface{}{
+				map[string]interface{}{
+					"Namespace":  namespace,
+					"Dimensions": [][]string{{"FunctionName"}},
+					"Metrics":    metrics,
+				},
+			},
+		},
+		"FunctionName": os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
+	}
+	for name, v := range values {
+		doc[name] = v
+	}
+
+	b, err := json.Marshal(doc)
+	if err != nil {
+		log.Printf("failed to encode the metrics: %s", err)
+		return
+	}
+	// not by log, a line must be a JSON object
+	fmt.Fprintln(os.Stdout, string(b))
+}
@@ -0,0 +1,135 @@
+package cwa2mkr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apex/go-apex/sns"
+	"github.com/aws/aws-sdk-go/aws"
+	awscreds "github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// fakeCall is a request to the fake servers.
+type fakeCall struct {
+	// "DynamoDB_20120810.PutItem" for AWS, or "POST /api/v0/tsdb" for mackerel
+	op    string
+	query url.Values
+	body  map[string]interface{}
+}
+
+// fakeServer records the requests, and responds by handler (200 with {} if nil).
+type fakeServer struct {
+	*httptest.Server
+	handler func(call fakeCall) (int, interface{})
+
+	mu    sync.Mutex
+	calls []fakeCall
+}
+
+func newFakeServer(t *testing.T, handler func(call fakeCall) (int, interface{})) *fakeServer {
+	t.Helper()
+	s := &fakeServer{handler: handler}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		call := fakeCall{op: r.Header.Get("X-Amz-Target"), query: r.URL.Query()}
+		if call.op == "" {
+			call.op = r.Method + " " + r.URL.Path
+		}
+		b, _ := ioutil.ReadAll(r.Body)
+		json.Unmarshal(b, &call.body)
+		s.mu.Lock()
+		s.calls = append(s.calls, call)
+		s.mu.Unlock()
+
+		code, resp := http.StatusOK, interface{}(map[string]interface{}{})
+		if s.handler != nil {
+			if c, v := s.handler(call); c != 0 {
+				code, resp = c, v
+			}
+		}
+		w.Header().Set("Content-Type", "application/x-amz-json-1.0")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(resp)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// called returns the calls of the operation, to the table if not empty.
+func (s *fakeServer) called(op, table string) []fakeCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var calls []fakeCall
+	for _, c := range s.calls {
+		if c.op == op && (table == "" || c.body["TableName"] == table) {
+			calls = append(calls, c)
+		}
+	}
+	return calls
+}
+
+// awsError is the body of an error response of the JSON protocol.
+func awsError(code string) map[string]interface{} {
+	return map[string]interface{}{"__type": "com.amazonaws.dynamodb.v20120810#" + code, "message": code}
+}
+
+// useFakeAWS points the AWS clients created later to the fake server.
+func useFakeAWS(t *testing.T, s *fakeServer) {
+	t.Helper()
+	awsSessOnce.Do(func() {})
+	prev := awsSess
+	awsSess = session.Must(session.NewSession(&aws.Config{
+		Endpoint:    aws.String(s.URL),
+		Region:      aws.String("ap-northeast-1"),
+		Credentials: awscreds.NewStaticCredentials("id", "secret", ""),
+		MaxRetries:  aws.Int(0),
+	}))
+	t.Cleanup(func() { awsSess = prev })
+}
+
+// testForwarder builds a forwarder by the settings, posting to the fake mackerel.
+func testForwarder(t *testing.T, mackerel *fakeServer, settings map[string]string) *forwarder {
+	t.Helper()
+	env := map[string]string{
+		"MACKEREL_APIKEY":   "apikey",
+		"MACKEREL_APIURL":   mackerel.URL,
+		"HOST_ID":           "host",
+		"POST_MAX_ATTEMPTS": "1",
+	}
+	for k, v := range settings {
+		env[k] = v
+	}
+	conf, err := parseConfig(func(name string) string { return env[name] })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return newForwarder(conf)
+}
+
+// testEvent returns the SNS event of the alarms.
+func testEvent(t *testing.T, alarms ...Alarm) (json.RawMessage, *sns.Event) {
+	t.Helper()
+	event := &sns.Event{}
+	for i, a := range alarms {
+		b, err := json.Marshal(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		record := &sns.Record{EventSource: "aws:sns"}
+		record.SNS.MessageID = "message-" + strings.Repeat("x", i+1)
+		record.SNS.TopicARN = "arn:aws:sns:ap-northeast-1:123456789012:alarms"
+		record.SNS.Message = string(b)
+		event.Records = append(event.Records, record)
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload, event
+}
@@ -0,0 +1,45 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+)
+
+// ways to report the alarms whose host is not resolved
+const (
+	fallbackAnnotation = "annotation"
+	fallbackMetric     = "metric"
+)
+
+// postToService reports the alarm to FALLBACK_SERVICE instead of a host.
+// the service and role from the resource tags are preferred.
+func (f *forwarder) postToService(ctx context.Context, msg Alarm, rep Report) error {
+	conf := f.conf
+	service, roles := conf.fallbackService, conf.fallbackRoles
+	if msg.Service != "" {
+		service, roles = msg.Service, nil
+		if msg.Role != "" {
+			roles = []string{msg.Role}
+		}
+	}
+
+	switch conf.fallbackMode {
+	case fallbackMetric:
+		return f.client.postServiceMetrics(ctx, service, []metricValue{
+			{
+				Name:  alarmMetricName(rep.Name),
+				Time:  rep.OccurredAt,
+				Value: statusMetricValues[rep.Status],
+			},
+		})
+	default:
+		return f.client.createGraphAnnotation(ctx, graphAnnotation{
+			Title:       fmt.Sprintf("%s is %s", rep.Name, rep.Status),
+			Description: rep.Message,
+			From:        rep.OccurredAt,
+			To:          rep.OccurredAt,
+			Service:     service,
+			Roles:       roles,
+		})
+	}
+}
@@ -0,0 +1,19 @@
+package cwa2mkr
+
+import (
+	"context"
+	"time"
+)
+
+const defaultFlushReserve = 3 * time.Second
+
+// flushContext returns the context to post to mackerel, which is done FLUSH_RESERVE_SECONDS before the invocation times out.
+// the reports not posted by then are saved to the retry queue or the dead letters in the reserved time,
+// instead of being killed mid-POST and lost.
+func (f *forwarder) flushContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	d, ok := ctx.Deadline()
+	if !ok || f.conf.flushReserve <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadline(ctx, d.Add(-f.conf.flushReserve))
+}
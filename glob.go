@@ -0,0 +1,25 @@
+package cwa2mkr
+
+import (
+	"regexp"
+	"strings"
+)
+
+// compileGlob converts a glob pattern to a regexp matching a whole string.
+// "*" matches any characters (including "/"), and "?" matches a character.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	for _, r := range pattern {
+		switch r {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.Compile(b.String())
+}
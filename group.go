@@ -0,0 +1,101 @@
+package cwa2mkr
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// statusSeverity is used to pick the worst status of grouped alarms.
+var statusSeverity = map[string]int{
+	StatusOK:       0,
+	StatusUnknown:  1,
+	StatusWarning:  2,
+	StatusCritical: 3,
+}
+
+func worseStatus(a, b string) string {
+	if statusSeverity[b] > statusSeverity[a] {
+		return b
+	}
+	return a
+}
+
+func (c *config) grouping() bool {
+	return c.groupPattern != nil || c.groupDimension != ""
+}
+
+// groupKey returns the check name the alarm is rolled up into.
+// empty means the alarm is not a member of any group.
+func (c *config) groupKey(msg Alarm) string {
+	target := msg.AlarmName
+	if c.groupDimension != "" {
+		if target = msg.Trigger.Dimension(c.groupDimension); target == "" {
+			return ""
+		}
+	}
+	if c.groupPattern == nil {
+		return target
+	}
+	m := c.groupPattern.FindStringSubmatch(target)
+	if len(m) < 2 {
+		return ""
+	}
+	return m[1]
+}
+
+// groupStore persists the last status of each group member in DynamoDB.
+//
+// table schema:
+//   - partition key: "group" (S)
+//   - sort key: "alarm" (S)
+type groupStore struct {
+	db    *dynamodb.DynamoDB
+	table string
+}
+
+func newGroupStore(table string) *groupStore {
+	return &groupStore{
+		db:    dynamodb.New(awsSession()),
+		table: table,
+	}
+}
+
+// update saves the status of the alarm, and returns the worst status of the group.
+func (s *groupStore) update(ctx context.Context, group, alarm, status string) (string, error) {
+	_, err := s.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"group":  {S: aws.String(group)},
+			"alarm":  {S: aws.String(alarm)},
+			"status": {S: aws.String(status)},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	worst := StatusOK
+	err = s.db.QueryPagesWithContext(ctx, &dynamodb.QueryInput{
+		TableName:                aws.String(s.table),
+		KeyConditionExpression:   aws.String("#group = :group"),
+		ExpressionAttributeNames: map[string]*string{"#group": aws.String("group")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":group": {S: aws.String(group)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}, func(out *dynamodb.QueryOutput, last bool) bool {
+		for _, item := range out.Items {
+			if v := item["status"]; v != nil && v.S != nil {
+				worst = worseStatus(worst, *v.S)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return worst, nil
+}
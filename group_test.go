@@ -0,0 +1,61 @@
+package cwa2mkr
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+func TestGroupWorstStatus(t *testing.T) {
+	// the statuses of the members in the fake GROUP_STATE_TABLE
+	var mu sync.Mutex
+	members := make(map[string]string)
+	aws := newFakeServer(t, func(call fakeCall) (int, interface{}) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch call.op {
+		case "DynamoDB_20120810.PutItem":
+			item := call.body["Item"].(map[string]interface{})
+			s := func(name string) string {
+				return item[name].(map[string]interface{})["S"].(string)
+			}
+			members[s("group")+"/"+s("alarm")] = s("status")
+		case "DynamoDB_20120810.Query":
+			var items []map[string]interface{}
+			for _, status := range members {
+				items = append(items, map[string]interface{}{"status": map[string]string{"S": status}})
+			}
+			return 200, map[string]interface{}{"Items": items}
+		}
+		return 0, nil
+	})
+	useFakeAWS(t, aws)
+	f := testForwarder(t, newFakeServer(t, nil), map[string]string{
+		"GROUP_PATTERN":     "^(web)-",
+		"GROUP_STATE_TABLE": "groups",
+		"REASON_RULES":      `[{"pattern": "^Threshold", "status": "CRITICAL"}]`,
+	})
+
+	tests := []struct {
+		alarm  Alarm
+		status string
+	}{
+		{testAlarm("web-1", "ALARM"), StatusCritical},
+		{testAlarm("web-2", "OK"), StatusCritical},
+		{testAlarm("web-2", "ALARM"), StatusCritical},
+		{testAlarm("web-1", "OK"), StatusCritical},
+		{testAlarm("web-2", "OK"), StatusOK},
+	}
+	for _, tt := range tests {
+		reps, err := f.buildReports(context.Background(), tt.alarm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reps) != 1 {
+			t.Fatalf("%d reports, want 1", len(reps))
+		}
+		if reps[0].Name != "web" || reps[0].Status != tt.status {
+			t.Errorf("%s %s: reported %s as %s, want web as %s", tt.alarm.AlarmName, tt.alarm.NewStateValue, reps[0].Name, reps[0].Status, tt.status)
+		}
+	}
+}
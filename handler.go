@@ -0,0 +1,718 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/apex/go-apex/sns"
+)
+
+// forwarder handles the events invoking the lambda.
+type forwarder struct {
+	conf      *config
+	client    *mackerelClient
+	groups    *groupStore
+	states    *stateStore
+	downtimes *downtimes
+
+	deadLetters []deadLetterWriter
+	audit       auditWriter
+	republisher *republisher
+	archiver    *archiver
+	retryQueue  *retryQueue
+	notifiers   []notifier
+	breaker     *circuitBreaker
+	buffer      *bufferQueue
+	outbox      *outbox
+	ops         *opsAlerter
+	idempotency *idempotencyStore
+
+	// POST_RATE_LIMIT shared by the loader, see buffer.go
+	limiter *rateLimiter
+
+	mu sync.Mutex
+	// keyed by API key of the other organizations than MACKEREL_APIKEY
+	otherClients   map[string]*mackerelClient
+	otherDowntimes map[string]*downtimes
+}
+
+func newForwarder(conf *config) *forwarder {
+	f := &forwarder{
+		conf:      conf,
+		client:    conf.newClient(conf.apiKey),
+		notifiers: conf.registeredNotifiers(),
+	}
+	if conf.grouping() {
+		f.groups = newGroupStore(conf.groupStateTable)
+	}
+	if conf.stateTable != "" {
+		f.states = newStateStore(conf.stateTable)
+	}
+	if conf.downtimeAction != "" {
+		f.downtimes = newDowntimes(f.client)
+	}
+	if conf.outboxTable != "" {
+		f.outbox = newOutbox(conf.outboxTable, conf.outboxMaxAttempts)
+	}
+	if conf.bufferQueueURL != "" {
+		f.buffer = newBufferQueue(conf.bufferQueueURL)
+	}
+	if conf.idempotencyTable != "" {
+		f.idempotency = newIdempotencyStore(conf.idempotencyTable, conf.idempotencyTTL, conf.idempotencyKey)
+	}
+	if conf.deadLetterBucket != "" {
+		f.deadLetters = append(f.deadLetters, newS3DeadLetters(conf.deadLetterBucket, conf.deadLetterPrefix))
+	}
+	if conf.deadLetterQueueURL != "" {
+		f.deadLetters = append(f.deadLetters, newSQSDeadLetters(conf.deadLetterQueueURL))
+	}
+	if conf.firehoseStream != "" {
+		f.archiver = newArchiver(conf.firehoseStream)
+	}
+	if conf.retryQueueURL != "" {
+		f.retryQueue = newRetryQueue(conf.retryQueueURL, conf.retryDelay, conf.retryMaxAttempts)
+	}
+	if conf.republishTopic != "" {
+		f.republisher = newRepublisher(conf.republishTopic)
+	}
+	switch {
+	case conf.auditTable != "":
+		f.audit = newDynamoAudit(conf.auditTable)
+	case conf.auditBucket != "":
+		f.audit = newS3Audit(conf.auditBucket, conf.auditPrefix)
+	}
+	return f
+}
+
+// event is used to tell the kind of the payload.
+type event struct {
+	Records    []json.RawMessage `json:"Records"`
+	DetailType string            `json:"detail-type"`
+
+	// the constant input of EventBridge rules, "digest" or "drain_outbox"
+	Action string `json:"action"`
+
+	// {"selfTest": true} as a canary, see selftest.go
+	SelfTest bool `json:"selfTest"`
+}
+
+// handle handles the event, and returns the response to lambda (the partial batch response to SQS), or nil.
+func (f *forwarder) handle(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	ctx = f.conf.withHTTP(ctx)
+	if f.conf.selfMetricsNamespace != "" {
+		var stats *invocationStats
+		ctx, stats = withStats(ctx)
+		defer stats.emit(f.conf.selfMetricsNamespace)
+	}
+
+	var e event
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, err
+	}
+
+	if e.Action == "digest" {
+		return nil, f.digest(ctx)
+	}
+
+	if e.Action == "drain_outbox" {
+		return nil, f.drainOutbox(ctx)
+	}
+
+	if e.SelfTest {
+		res, err := f.selfTest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return res, nil
+	}
+
+	// invoked by EventBridge schedule rule
+	if e.DetailType == "Scheduled Event" {
+		return nil, f.sweep(ctx)
+	}
+
+	// invoked by SQS event source mapping of RETRY_QUEUE_URL
+	if len(e.Records) > 0 {
+		var first sqsRecord
+		if err := json.Unmarshal(e.Records[0], &first); err == nil && first.EventSource == "aws:sqs" {
+			records := make([]sqsRecord, 0, len(e.Records))
+			for _, r := range e.Records {
+				var record sqsRecord
+				if err := json.Unmarshal(r, &record); err != nil {
+					return nil, err
+				}
+				records = append(records, record)
+			}
+			resp, err := f.drain(ctx, records)
+			if resp == nil {
+				return nil, err
+			}
+			return resp, err
+		}
+	}
+
+	var snsEvent sns.Event
+	if err := json.Unmarshal(payload, &snsEvent); err != nil {
+		return nil, err
+	}
+	if f.conf.bufferMode == bufferReceiver {
+		return nil, f.receive(ctx, &snsEvent)
+	}
+	return nil, f.handleSNS(ctx, payload, &snsEvent)
+}
+
+func (f *forwarder) handleSNS(ctx context.Context, payload json.RawMessage, event *sns.Event) error {
+	reps := Reports{
+		Reports: make([]Report, 0, len(event.Records)),
+	}
+	// the alarms to republish after posted
+	var processed []republished
+	var archive []archived
+	// a failed record does not block the others, and the errors are reported together at last
+	var errs batchErrors
+
+	// the alarms claimed by IDEMPOTENCY_TABLE are released on the errors before posting,
+	// not to skip them by the retry of lambda as delivered already
+	var claimed []*Alarm
+	posted := false
+	defer func() {
+		if posted {
+			return
+		}
+		for _, msg := range claimed {
+			f.release(ctx, msg)
+		}
+	}()
+
+	for _, record := range event.Records {
+		r, err := f.processRecord(ctx, record)
+		if err != nil {
+			log.Printf("failed to process the record %s: %s", record.SNS.MessageID, err)
+			if err := f.recordFailed(ctx, record, err); err != nil {
+				errs = append(errs, err)
+				f.release(ctx, r.claimed)
+			}
+			continue
+		}
+		if r.claimed != nil {
+			claimed = append(claimed, r.claimed)
+		}
+		reps.Reports = append(reps.Reports, r.reports...)
+		processed = append(processed, r.republish...)
+		if r.archive != nil {
+			archive = append(archive, *r.archive)
+		}
+	}
+
+	if f.conf.hostStateMetrics && len(reps.Reports) > 0 {
+		if err := f.postHostStateMetrics(ctx, reps.Reports); err != nil {
+			return err
+		}
+	}
+
+	mirrored := f.conf.mirror(reps)
+
+	// written before posting, not to lose the reports by a crash
+	var outboxID string
+	if f.outbox != nil && len(mirrored.Reports) > 0 && f.conf.outputMode != outputStdout {
+		id, err := f.outbox.put(ctx, mirrored)
+		if err != nil {
+			return batchErrors(append(errs, err)).err()
+		}
+		outboxID = id
+	}
+
+	postCtx, cancel := f.flushContext(ctx)
+	failed, attempts, postErr := f.post(postCtx, mirrored)
+	cancel()
+	// the failed reports are released below unless deferred to be retried
+	posted = true
+
+	if outboxID != "" && postErr == nil {
+		if err := f.outbox.delivered(ctx, outboxID); err != nil {
+			log.Printf("failed to mark the outbox %s delivered, so it may be posted again: %s", outboxID, err)
+		}
+	}
+
+	// saved first, in the time reserved by flushContext
+	if postErr != nil {
+		if postCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+			log.Printf("the invocation is about to time out, so save %d reports not posted", len(failed.Reports))
+		}
+		var err error
+		switch {
+		// pending in the outbox to be drained
+		case outboxID != "":
+			err = f.outbox.failed(ctx, outboxID, failed, attempts, postErr)
+		// straight to the dead letters during the outage
+		case f.retryQueue != nil && !errors.Is(postErr, errCircuitOpen):
+			err = f.retryQueue.enqueue(ctx, failed, attempts, 1, postErr)
+		default:
+			err = f.deadLetter(ctx, payload, failed, attempts, postErr)
+		}
+		if err != nil {
+			errs = append(errs, err)
+			for _, rep := range failed.Reports {
+				f.release(ctx, rep.alarm)
+			}
+		}
+	}
+
+	if f.archiver != nil && len(archive) > 0 {
+		if err := f.archiver.put(ctx, archive); err != nil {
+			log.Printf("failed to archive the alarms: %s", err)
+		}
+	}
+
+	// the failure-only notifiers are the fallback while mackerel is unreachable, not for the reports rejected
+	var unreachable []Report
+	for _, rep := range failed.Reports {
+		if rep.unreachable {
+			unreachable = append(unreachable, rep)
+		}
+	}
+	if err := notifyAll(ctx, f.notifiers, reps.Reports, unreachable); err != nil {
+		logNotifyErrors(err)
+	}
+
+	// go on for the other reports posted
+	if postErr != nil && len(failed.Reports) == len(mirrored.Reports) {
+		return errs.err()
+	}
+
+	if f.states != nil && len(reps.Reports) > 0 {
+		f.statesChanged(ctx)
+	}
+
+	// the reports are delivered already, so the failures below are only logged,
+	// not to post them again by the retry of lambda
+	if f.conf.closeAlerts {
+		if err := f.closeAlerts(ctx, reps); err != nil {
+			log.Printf("failed to close the alerts: %s", err)
+		}
+	}
+
+	if f.conf.verifyDelivery && f.conf.outputMode != outputStdout && postErr == nil && len(mirrored.Reports) > 0 {
+		f.scheduleVerification(ctx, mirrored)
+	}
+
+	if f.republisher != nil {
+		for _, p := range processed {
+			if err := f.republisher.publish(ctx, p); err != nil {
+				log.Printf("failed to republish %s: %s", p.report.Name, err)
+			}
+		}
+	}
+	return errs.err()
+}
+
+// processedRecord is the result of a record of SNS events.
+type processedRecord struct {
+	// the reports to post to the hosts
+	reports   []Report
+	republish []republished
+	archive   *archived
+
+	// the alarm claimed by IDEMPOTENCY_TABLE
+	claimed *Alarm
+}
+
+// processRecord converts a record to the reports, and outputs the ones not to post to the hosts (e.g. to the services).
+func (f *forwarder) processRecord(ctx context.Context, record *sns.Record) (processedRecord, error) {
+	var r processedRecord
+
+	var msg Alarm
+	if err := json.Unmarshal([]byte(record.SNS.Message), &msg); err != nil {
+		log.Println(err)
+		statsFrom(ctx).parseError()
+		unknown, err := f.conf.unknownMessage(record, err)
+		r.reports = unknown
+		return r, err
+	}
+
+	msg.TopicArn = record.SNS.TopicARN
+	msg.PublishedAt = record.SNS.Timestamp
+	msg.MessageID = record.SNS.MessageID
+
+	// empty is not expected, so skip (by UNKNOWN_MESSAGE_ACTION).
+	if msg.AlarmName == "" || msg.NewStateValue == "" {
+		log.Printf("got the unknown message: %#v", msg)
+		statsFrom(ctx).skipped()
+		unknown, err := f.conf.unknownMessage(record, errEmptyAlarm)
+		r.reports = unknown
+		return r, err
+	}
+
+	if f.idempotency != nil {
+		ok, err := f.idempotency.claim(ctx, msg)
+		if err != nil {
+			return r, err
+		}
+		if !ok {
+			log.Printf("skip the alarm delivered already: %s %s", msg.AlarmName, msg.MessageID)
+			statsFrom(ctx).skipped()
+			return r, nil
+		}
+		r.claimed = &msg
+	}
+
+	if err := f.conf.enrich(ctx, &msg); err != nil {
+		return r, err
+	}
+
+	if m := f.conf.maintenance; m != nil {
+		ok, err := m.is(ctx, msg)
+		if err != nil {
+			return r, err
+		}
+		if ok {
+			return r, f.maintain(ctx, msg)
+		}
+	}
+
+	built, err := f.buildReports(ctx, msg)
+	if err != nil {
+		return r, err
+	}
+	if len(built) == 0 {
+		statsFrom(ctx).skipped()
+		return r, nil
+	}
+	if f.archiver != nil {
+		a := newArchived(msg.TopicArn, record.SNS.Message, built)
+		r.archive = &a
+	}
+	r.republish = []republished{{message: record.SNS.Message, report: built[0]}}
+
+	if f.conf.stateMetricService != "" {
+		if err := f.postStateMetric(ctx, built[0]); err != nil {
+			return r, err
+		}
+	}
+
+	if f.conf.annotationService != "" {
+		if err := f.annotate(ctx, msg, built[0]); err != nil {
+			return r, err
+		}
+		if f.conf.annotationOnly {
+			return r, nil
+		}
+	}
+
+	var reports []Report
+	for _, rep := range built {
+		if rep.Source.HostID == "" {
+			if err := f.postToService(ctx, msg, rep); err != nil {
+				return r, err
+			}
+			continue
+		}
+		if f.states != nil {
+			if err := f.states.put(ctx, msg.AlarmName, rep); err != nil {
+				return r, err
+			}
+		}
+		reports = append(reports, rep)
+	}
+	r.reports = reports
+	return r, nil
+}
+
+// release releases the alarm claimed by IDEMPOTENCY_TABLE, to post it by the retry of lambda.
+func (f *forwarder) release(ctx context.Context, msg *Alarm) {
+	if f.idempotency == nil || msg == nil {
+		return
+	}
+	if err := f.idempotency.release(ctx, *msg); err != nil {
+		log.Printf("failed to release the idempotency key of %s: %s", msg.AlarmName, err)
+	}
+}
+
+// recordFailed saves the failed record to the dead letters, and the error is returned if not saved.
+func (f *forwarder) recordFailed(ctx context.Context, record *sns.Record, err error) error {
+	body, _ := json.Marshal(sns.Event{Records: []*sns.Record{record}})
+	return f.deadLetter(ctx, body, Reports{}, 0, err)
+}
+
+// batchErrors aggregates the errors of the records or the reports, not to fail the others by one.
+type batchErrors []error
+
+func (e batchErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Is reports whether any of the errors is target, for errors.Is.
+// e.g. errCircuitOpen of a chunk or an organization sends the whole batch to the dead letters.
+func (e batchErrors) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// err returns nil if no error, or the error itself if only one.
+func (e batchErrors) err() error {
+	switch len(e) {
+	case 0:
+		return nil
+	case 1:
+		return e[0]
+	}
+	return e
+}
+
+// clientFor returns the client for the organization of the source.
+func (f *forwarder) clientFor(src Source) *mackerelClient {
+	if src.apiKey == "" || src.apiKey == f.conf.apiKey {
+		return f.client
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.otherClients == nil {
+		f.otherClients = make(map[string]*mackerelClient)
+	}
+	c, ok := f.otherClients[src.apiKey]
+	if !ok {
+		c = f.conf.newClient(src.apiKey)
+		f.otherClients[src.apiKey] = c
+	}
+	return c
+}
+
+// downtimesFor returns the downtimes of the organization of the source.
+func (f *forwarder) downtimesFor(src Source) *downtimes {
+	if src.apiKey == "" || src.apiKey == f.conf.apiKey {
+		return f.downtimes
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.otherDowntimes == nil {
+		f.otherDowntimes = make(map[string]*downtimes)
+	}
+	d, ok := f.otherDowntimes[src.apiKey]
+	if !ok {
+		d = newDowntimes(f.conf.newClient(src.apiKey))
+		f.otherDowntimes[src.apiKey] = d
+	}
+	return d
+}
+
+// post posts the reports to the organization of each source, and records them to the audit trail.
+// the reports are posted in the chunks (see chunk.go), and a failure of a chunk does not block the others.
+// the reports rejected by 4xx are posted one by one not to be blocked by a bad report (e.g. a retired host).
+// the reports failed to post and the number of tries to post them are returned with the error.
+func (f *forwarder) post(ctx context.Context, reps Reports) (Reports, int, error) {
+	if f.conf.outputMode == outputStdout {
+		return Reports{}, 0, printReports(reps)
+	}
+
+	// the invalid reports fail without sending
+	reps, failed, errs := checkReports(reps)
+	var attempts int
+	if len(failed.Reports) > 0 {
+		// counted as a try, not to be retried forever
+		attempts = 1
+	}
+
+	byKey := make(map[string]*Reports)
+	var keys []string
+	for _, rep := range reps.Reports {
+		key := rep.Source.apiKey
+		if key == "" {
+			key = f.conf.apiKey
+		}
+		if byKey[key] == nil {
+			byKey[key] = &Reports{}
+			keys = append(keys, key)
+		}
+		byKey[key].Reports = append(byKey[key].Reports, rep)
+	}
+
+	fail := func(group Reports, code, n int, err error) {
+		for _, rep := range group.Reports {
+			rep.unreachable = !rejected(code)
+			failed.Reports = append(failed.Reports, rep)
+		}
+		if n > attempts {
+			attempts = n
+		}
+		errs = append(errs, err)
+	}
+	for _, key := range keys {
+		for _, group := range chunkReports(*byKey[key]) {
+			code, n, err := f.postGroup(ctx, key, group)
+			if err == nil {
+				continue
+			}
+			if !rejected(code) || len(group.Reports) == 1 {
+				fail(group, code, n, err)
+				continue
+			}
+			log.Printf("%d reports are rejected, so post them one by one: %s", len(group.Reports), err)
+			for _, rep := range group.Reports {
+				one := Reports{Reports: []Report{rep}}
+				if code, n, err := f.postGroup(ctx, key, one); err != nil {
+					fail(one, code, n, fmt.Errorf("%s of %s: %w", rep.Name, rep.Source.HostID, err))
+				}
+			}
+		}
+	}
+	err := errs.err()
+	if len(reps.Reports)+len(failed.Reports) > 0 {
+		f.ops.record(len(failed.Reports), err)
+	}
+	return failed, attempts, err
+}
+
+// postGroup posts the reports to an organization through the circuit breaker, and records them to the audit trail.
+func (f *forwarder) postGroup(ctx context.Context, key string, reps Reports) (int, int, error) {
+	if !f.breaker.allow() {
+		statsFrom(ctx).apiFailure()
+		return 0, 0, errCircuitOpen
+	}
+	if err := f.limiter.wait(ctx, len(reps.Reports)); err != nil {
+		return 0, 0, err
+	}
+	code, attempts, err := retryChecksReport(ctx, f.conf.postRetry, f.conf.apiURL, key, reps)
+	f.breaker.record(err != nil && !rejected(code))
+	if f.audit != nil {
+		if auditErr := f.audit.write(ctx, newAuditRecords(reps, code, err)); auditErr != nil {
+			log.Printf("failed to write the audit trail: %s", auditErr)
+		}
+	}
+	if err != nil {
+		statsFrom(ctx).apiFailure()
+		return code, attempts, err
+	}
+	statsFrom(ctx).posted(len(reps.Reports))
+	return code, attempts, nil
+}
+
+// buildReports converts the alarm to the reports for each host. nothing is returned if the alarm should not be reported.
+// a report without host id is returned when the host is not resolved and FALLBACK_SERVICE is set.
+func (f *forwarder) buildReports(ctx context.Context, msg Alarm) ([]Report, error) {
+	conf := f.conf
+
+	sources, resolveErr := conf.resolveSources(ctx, msg)
+	if resolveErr != nil {
+		if conf.fallbackHostID == "" {
+			return nil, resolveErr
+		}
+		log.Printf("failed to resolve the host of %s, so fall back to %s: %s", msg.AlarmName, conf.fallbackHostID, resolveErr)
+		sources = []Source{{Type: "host", HostID: conf.fallbackHostID}}
+	}
+
+	rep := Report{
+		Status:     conf.mackerelStatus(msg),
+		OccurredAt: conf.occurredAt(msg),
+		alarm:      &msg,
+	}
+	var err error
+	if rep.Name, err = conf.checkName(msg); err != nil {
+		return nil, err
+	}
+	var head, note string
+	// noted with the original time within the budget of the message, not to truncate the links
+	if at, ok := clampOccurredAt(rep.OccurredAt, time.Now()); ok {
+		head = occurredAtHead(rep.OccurredAt)
+		rep.OccurredAt = at
+	}
+	if resolveErr != nil {
+		note = fmt.Sprintf(" (unresolved source: %s)", resolveErr)
+	}
+
+	if msg.causedByMissingData() {
+		switch conf.missingData {
+		case missingDataSkip:
+			log.Printf("skip the alarm caused by missing data: %s", msg.AlarmName)
+			return nil, nil
+		case missingDataUnknown:
+			rep.Status = StatusUnknown
+		}
+	}
+
+	rep.Status = adjustStatus(conf.reasonRules, rep.Status, msg)
+
+	if f.groups != nil {
+		if key := conf.groupKey(msg); key != "" {
+			worst, err := f.groups.update(ctx, key, msg.AlarmName, rep.Status)
+			if err != nil {
+				return nil, err
+			}
+			rep.Name = key
+			rep.Status = worst
+		}
+	}
+
+	rep.NotificationInterval = conf.notificationInterval(rep.Status, msg)
+
+	if rep.Name, err = rewriteName(conf.rewriteRules, rep.Name, msg); err != nil {
+		return nil, err
+	}
+
+	// the message is rendered by the status posted finally, adjusted above and by the downtimes below
+	if len(sources) == 0 {
+		if rep.Message, err = conf.message(msg, rep.Status, head, note); err != nil {
+			return nil, err
+		}
+		return []Report{rep}, nil
+	}
+
+	reps := make([]Report, 0, len(sources))
+	for _, src := range sources {
+		if conf.retiredAction != "" {
+			alive, err := f.hostAlive(ctx, src)
+			if err != nil {
+				return nil, err
+			}
+			if !alive {
+				if conf.retiredAction == retiredSkip || len(conf.hostIDs) == 0 || conf.hostIDs[0] == src.HostID {
+					log.Printf("skip %s, because the host %s is retired or not found", rep.Name, src.HostID)
+					continue
+				}
+				log.Printf("the host %s is retired or not found, so report %s to %s", src.HostID, rep.Name, conf.hostIDs[0])
+				src = Source{Type: "host", HostID: conf.hostIDs[0]}
+			}
+		}
+
+		r := rep
+		r.Source = src
+
+		if f.downtimes != nil && r.Status != StatusOK {
+			dt, err := f.downtimesFor(src).active(ctx, src.HostID)
+			if err != nil {
+				return nil, err
+			}
+			if dt != nil {
+				switch conf.downtimeAction {
+				case downtimeSkip:
+					log.Printf("skip %s on %s in the downtime %s", r.Name, src.HostID, dt.Name)
+					continue
+				case downtimeDowngrade:
+					if r.Status == StatusCritical {
+						r.Status = StatusWarning
+					}
+				}
+			}
+		}
+
+		if r.Message, err = conf.message(msg, r.Status, head, note); err != nil {
+			return nil, err
+		}
+		reps = append(reps, r)
+	}
+
+	return reps, nil
+}
@@ -0,0 +1,201 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func testAlarm(name, state string) Alarm {
+	return Alarm{
+		AlarmName:       name,
+		AlarmArn:        "arn:aws:cloudwatch:ap-northeast-1:123456789012:alarm:" + name,
+		NewStateValue:   state,
+		OldStateValue:   "OK",
+		NewStateReason:  "Threshold Crossed",
+		StateChangeTime: "2018-02-16T08:42:33.109+0000",
+	}
+}
+
+func TestHandleSNSReleasesClaimed(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		// the responses of the fakes, 0 to succeed
+		claim, outboxPut, metrics, post int
+		wantErr                         bool
+		wantPosts, wantReleases         int
+	}{
+		{name: "delivered", wantPosts: 1},
+		{name: "claimed already", claim: http.StatusBadRequest},
+		{name: "host state metrics failed", settings: map[string]string{"HOST_STATE_METRICS": "1"}, metrics: http.StatusInternalServerError, wantErr: true, wantReleases: 1},
+		{name: "outbox failed", settings: map[string]string{"OUTBOX_TABLE": "outbox"}, outboxPut: http.StatusInternalServerError, wantErr: true, wantReleases: 1},
+		{name: "post failed and not saved", post: http.StatusBadRequest, wantErr: true, wantPosts: 1, wantReleases: 1},
+		{name: "post failed and deferred to the outbox", settings: map[string]string{"OUTBOX_TABLE": "outbox"}, post: http.StatusBadRequest, wantPosts: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := newFakeServer(t, func(call fakeCall) (int, interface{}) {
+				switch {
+				case call.op == "DynamoDB_20120810.PutItem" && call.body["TableName"] == "idempotency" && tt.claim != 0:
+					return tt.claim, awsError("ConditionalCheckFailedException")
+				case call.op == "DynamoDB_20120810.PutItem" && call.body["TableName"] == "outbox" && tt.outboxPut != 0:
+					return tt.outboxPut, awsError("InternalServerError")
+				}
+				return 0, nil
+			})
+			useFakeAWS(t, db)
+			mackerel := newFakeServer(t, func(call fakeCall) (int, interface{}) {
+				switch call.op {
+				case "POST /api/v0/tsdb":
+					return tt.metrics, map[string]string{"error": "metrics"}
+				case "POST " + checkReportPath:
+					return tt.post, map[string]string{"error": "post"}
+				}
+				return 0, nil
+			})
+			settings := map[string]string{"IDEMPOTENCY_TABLE": "idempotency"}
+			for k, v := range tt.settings {
+				settings[k] = v
+			}
+			f := testForwarder(t, mackerel, settings)
+
+			payload, event := testEvent(t, testAlarm("alarm", "ALARM"))
+			err := f.handleSNS(context.Background(), payload, event)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := len(mackerel.called("POST "+checkReportPath, "")); got != tt.wantPosts {
+				t.Errorf("posted %d times, want %d", got, tt.wantPosts)
+			}
+			if got := len(db.called("DynamoDB_20120810.DeleteItem", "idempotency")); got != tt.wantReleases {
+				t.Errorf("released %d times, want %d", got, tt.wantReleases)
+			}
+		})
+	}
+}
+
+// recordingNotifier records the names of the reports notified.
+type recordingNotifier struct {
+	mu    sync.Mutex
+	names []string
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, rep Report) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.names = append(n.names, rep.Name)
+	return nil
+}
+
+func TestHandleSNSNotifiesFailures(t *testing.T) {
+	tests := []struct {
+		name string
+		// the response to the reports posted together
+		respond     func(names []string) int
+		wantFailure int
+	}{
+		{
+			name:    "delivered",
+			respond: func(names []string) int { return 0 },
+		},
+		{
+			name: "a chunk unreachable",
+			respond: func(names []string) int {
+				if len(names) == 50 {
+					return http.StatusInternalServerError
+				}
+				return 0
+			},
+			wantFailure: 50,
+		},
+		{
+			name: "a report rejected",
+			respond: func(names []string) int {
+				for _, name := range names {
+					if name == "alarm-7" {
+						return http.StatusBadRequest
+					}
+				}
+				return 0
+			},
+		},
+		{
+			name:        "all unreachable",
+			respond:     func(names []string) int { return http.StatusServiceUnavailable },
+			wantFailure: 150,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mackerel := newFakeServer(t, func(call fakeCall) (int, interface{}) {
+				if call.op != "POST "+checkReportPath {
+					return 0, nil
+				}
+				reps, _ := call.body["reports"].([]interface{})
+				names := make([]string, 0, len(reps))
+				for _, r := range reps {
+					names = append(names, r.(map[string]interface{})["name"].(string))
+				}
+				return tt.respond(names), map[string]string{"error": "post"}
+			})
+			f := testForwarder(t, mackerel, nil)
+			all, failure := &recordingNotifier{}, &recordingNotifier{}
+			f.notifiers = []notifier{
+				{name: "all", Notifier: all},
+				{name: "failure", Notifier: failure, onlyFailure: true},
+			}
+
+			alarms := make([]Alarm, 150)
+			for i := range alarms {
+				alarms[i] = testAlarm(fmt.Sprintf("alarm-%d", i), "ALARM")
+			}
+			payload, event := testEvent(t, alarms...)
+			f.handleSNS(context.Background(), payload, event)
+
+			if len(all.names) != len(alarms) {
+				t.Errorf("notified %d reports to all, want %d", len(all.names), len(alarms))
+			}
+			if len(failure.names) != tt.wantFailure {
+				t.Errorf("notified %d reports to failure, want %d", len(failure.names), tt.wantFailure)
+			}
+		})
+	}
+}
+
+func TestBuildReportsMessageStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings map[string]string
+		alarm    Alarm
+		status   string
+	}{
+		{"alarm", nil, testAlarm("alarm", "ALARM"), "WARNING"},
+		{"reason rules", map[string]string{"REASON_RULES": `[{"pattern": "^Threshold", "status": "CRITICAL"}]`}, testAlarm("alarm", "ALARM"), "CRITICAL"},
+		{"missing data", map[string]string{"MISSING_DATA_ACTION": "unknown"}, testAlarm("alarm", "INSUFFICIENT_DATA"), "UNKNOWN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mackerel := newFakeServer(t, nil)
+			settings := map[string]string{"MESSAGE_TEMPLATE": "status: {{ .Status }}"}
+			for k, v := range tt.settings {
+				settings[k] = v
+			}
+			f := testForwarder(t, mackerel, settings)
+
+			reps, err := f.buildReports(context.Background(), tt.alarm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(reps) != 1 {
+				t.Fatalf("%d reports, want 1", len(reps))
+			}
+			if string(reps[0].Status) != tt.status || !strings.HasSuffix(reps[0].Message, "status: "+tt.status) {
+				t.Errorf("posted %s with the message %q, want %s", reps[0].Status, reps[0].Message, tt.status)
+			}
+		})
+	}
+}
@@ -0,0 +1,142 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// SourceResolver finds the mackerel sources to report the alarm.
+// empty result means the resolver has no opinion, and the next resolver is tried.
+type SourceResolver interface {
+	ResolveSources(ctx context.Context, alarm Alarm) ([]Source, error)
+}
+
+// StaticSourceResolver reports all alarms to the fixed hosts, which is the default by HOST_ID.
+type StaticSourceResolver struct {
+	HostIDs []string
+}
+
+func (r StaticSourceResolver) ResolveSources(ctx context.Context, alarm Alarm) ([]Source, error) {
+	sources := make([]Source, 0, len(r.HostIDs))
+	for _, hostID := range r.HostIDs {
+		sources = append(sources, Source{Type: "host", HostID: hostID})
+	}
+	return sources, nil
+}
+
+// hostResolver finds the mackerel host id to report the alarm, which is a simpler SourceResolver.
+// empty host id means the resolver has no opinion.
+type hostResolver interface {
+	resolveHost(ctx context.Context, msg Alarm) (string, error)
+}
+
+// hostSources adapts hostResolver to SourceResolver.
+type hostSources struct {
+	hostResolver
+}
+
+func (r hostSources) ResolveSources(ctx context.Context, msg Alarm) ([]Source, error) {
+	hostID, err := r.resolveHost(ctx, msg)
+	if err != nil || hostID == "" {
+		return nil, err
+	}
+	return []Source{{Type: "host", HostID: hostID}}, nil
+}
+
+// resolveSources tries the resolvers in order, and falls back to HOST_ID (may be several hosts).
+// nil is returned if FALLBACK_SERVICE is set instead.
+func (c *config) resolveSources(ctx context.Context, msg Alarm) ([]Source, error) {
+	for _, r := range c.resolvers {
+		sources, err := r.ResolveSources(ctx, msg)
+		if err != nil {
+			return nil, err
+		}
+		if len(sources) > 0 {
+			return sources, nil
+		}
+	}
+	if c.fallbackService != "" {
+		return nil, nil
+	}
+	return StaticSourceResolver{HostIDs: c.hostIDs}.ResolveSources(ctx, msg)
+}
+
+// hostRoute selects the host by an alarm tag, a dimension, the alarm name, the namespace, the AWS account or the SNS topic.
+//
+//	[
+//	  {"topic": "arn:aws:sns:ap-northeast-1:123456789012:prod-alarms", "host_id": "prod"},
+//	  {"alarm": "web-*", "host_id": "hostA"},
+//	  {"namespace": "AWS/RDS", "host_id": "databases"},
+//	  {"account": "123456789012", "host_id": "hostC", "api_key": "xxx"},
+//	  {"account": "210987654321", "host_id": "hostD", "credential": "dev"},
+//	  {"tag": "Team", "value": "web", "host_id": "hostA"},
+//	  {"dimension": "ClusterName", "value": "data", "host_id": "hostB"}
+//	]
+type hostRoute struct {
+	alarmSelector
+
+	HostID string `json:"host_id"`
+
+	// [optional] API key of the organization of the host, or the name of it in MACKEREL_APIKEYS
+	APIKey     string `json:"api_key"`
+	Credential string `json:"credential"`
+}
+
+// hostRoutes resolves the source by the first matched route.
+type hostRoutes struct {
+	routes []hostRoute
+	tags   *tagCache
+}
+
+func parseHostRoutes(s string, creds credentials) (*hostRoutes, error) {
+	var routes []hostRoute
+	if err := json.Unmarshal([]byte(s), &routes); err != nil {
+		return nil, err
+	}
+	r := &hostRoutes{routes: routes}
+	for i := range routes {
+		route := &routes[i]
+		if err := route.compile(); err != nil {
+			return nil, fmt.Errorf("route[%d]: %s", i, err)
+		}
+		if route.HostID == "" {
+			return nil, fmt.Errorf("route[%d]: host_id is required", i)
+		}
+		var err error
+		if route.APIKey, err = creds.apiKey(route.APIKey, route.Credential); err != nil {
+			return nil, fmt.Errorf("route[%d]: %s", i, err)
+		}
+		if route.Tag != "" && r.tags == nil {
+			r.tags = newAlarmTags()
+		}
+	}
+	return r, nil
+}
+
+func (r *hostRoutes) ResolveSources(ctx context.Context, msg Alarm) ([]Source, error) {
+	// the routes are evaluated every time, as the topic of the same alarm may differ.
+	// the tags are cached, not to call ListTagsForResource every time.
+	i, err := r.route(ctx, msg)
+	if err != nil || i < 0 {
+		return nil, err
+	}
+
+	route := r.routes[i]
+	return []Source{{Type: "host", HostID: route.HostID, apiKey: route.APIKey}}, nil
+}
+
+// route returns the index of the first matched route, or -1.
+func (r *hostRoutes) route(ctx context.Context, msg Alarm) (int, error) {
+	tags := &alarmTags{cache: r.tags}
+	for i, route := range r.routes {
+		ok, err := route.match(ctx, msg, tags)
+		if err != nil {
+			return -1, err
+		}
+		if ok {
+			return i, nil
+		}
+	}
+	return -1, nil
+}
@@ -0,0 +1,44 @@
+package cwa2mkr
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestHostRoutesByTopics(t *testing.T) {
+	useFakeAWS(t, newFakeServer(t, nil))
+	r, err := parseHostRoutes(`[
+		{"tag": "Team", "value": "web", "host_id": "web"},
+		{"topic": "arn:aws:sns:ap-northeast-1:123456789012:prod-alarms", "host_id": "prod"}
+	]`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := testAlarm("alarm", "ALARM")
+	r.tags.cache[msg.AlarmArn] = cachedTags{tags: map[string]string{}, expiresAt: time.Now().Add(time.Hour)}
+
+	// the same alarm published to the topics
+	tests := []struct {
+		topic  string
+		hostID string
+	}{
+		{"arn:aws:sns:ap-northeast-1:123456789012:dev-alarms", ""},
+		{"arn:aws:sns:ap-northeast-1:123456789012:prod-alarms", "prod"},
+		{"arn:aws:sns:ap-northeast-1:123456789012:dev-alarms", ""},
+	}
+	for _, tt := range tests {
+		msg.TopicArn = tt.topic
+		sources, err := r.ResolveSources(context.Background(), msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var hostID string
+		if len(sources) > 0 {
+			hostID = sources[0].HostID
+		}
+		if hostID != tt.hostID {
+			t.Errorf("routed %s to %q, want %q", tt.topic, hostID, tt.hostID)
+		}
+	}
+}
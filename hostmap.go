@@ -0,0 +1,58 @@
+package cwa2mkr
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// hostMapTable resolves the host by the DynamoDB table mapping dimensions to host ids,
+// which is maintained by infrastructure automations.
+//
+// table schema:
+//   - partition key: "dimension" (S) like "DBInstanceIdentifier=mydb"
+//   - "host_id" (S)
+type hostMapTable struct {
+	db    *dynamodb.DynamoDB
+	table string
+	cache *hostCache
+}
+
+func newHostMapTable(table string, cache *hostCache) *hostMapTable {
+	return &hostMapTable{
+		db:    dynamodb.New(awsSession()),
+		table: table,
+		cache: cache,
+	}
+}
+
+// resolveHost looks up the dimensions in order, and returns the first found host.
+func (t *hostMapTable) resolveHost(ctx context.Context, msg Alarm) (string, error) {
+	for _, d := range msg.Trigger.Dimensions {
+		key := d.Name + "=" + d.Value
+		hostID, err := t.cache.lookup(ctx, "table:"+key, func(ctx context.Context) (string, error) {
+			return t.get(ctx, key)
+		})
+		if err != nil {
+			return "", err
+		}
+		if hostID != "" {
+			return hostID, nil
+		}
+	}
+	return "", nil
+}
+
+func (t *hostMapTable) get(ctx context.Context, key string) (string, error) {
+	out, err := t.db.GetItemWithContext(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(t.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"dimension": {S: aws.String(key)},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+	return attrString(out.Item["host_id"]), nil
+}
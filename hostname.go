@@ -0,0 +1,65 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// hostNameResolver resolves the host whose name equals to the rendered template, via the mackerel hosts API.
+type hostNameResolver struct {
+	tmpl   *template.Template
+	client *mackerelClient
+	cache  *hostCache
+
+	// create a host when not found, with the roles like "service:role"
+	create bool
+	roles  []string
+}
+
+// newHostNameResolver parses the template executed with Alarm,
+// e.g. `{{ .Trigger.Dimension "InstanceId" }}`.
+func newHostNameResolver(text string, client *mackerelClient, cache *hostCache) (*hostNameResolver, error) {
+	tmpl, err := template.New("host_name").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &hostNameResolver{tmpl: tmpl, client: client, cache: cache}, nil
+}
+
+func (r *hostNameResolver) resolveHost(ctx context.Context, msg Alarm) (string, error) {
+	var b bytes.Buffer
+	if err := r.tmpl.Execute(&b, msg); err != nil {
+		return "", err
+	}
+	name := strings.TrimSpace(b.String())
+	if name == "" {
+		return "", nil
+	}
+
+	return r.cache.lookup(ctx, "name:"+name, func(ctx context.Context) (string, error) {
+		hosts, err := r.client.findHosts(ctx, url.Values{"name": {name}})
+		if err != nil {
+			return "", err
+		}
+		if len(hosts) > 0 {
+			return hosts[0].ID, nil
+		}
+		if !r.create {
+			return "", nil
+		}
+
+		hostID, err := r.client.createHost(ctx, createHostParam{
+			Name:          name,
+			RoleFullnames: r.roles,
+		})
+		if err != nil {
+			return "", err
+		}
+		log.Printf("registered the host %s (%s)", name, hostID)
+		return hostID, nil
+	})
+}
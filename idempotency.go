@@ -0,0 +1,100 @@
+package cwa2mkr
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	// IDEMPOTENCY_KEY
+	idempotencyMessageID = "message_id"
+	idempotencyAlarm     = "alarm"
+
+	// DELIVERY_MODE
+	atLeastOnce = "at_least_once"
+	exactlyOnce = "exactly_once"
+
+	defaultIdempotencyTTL = 24 * time.Hour
+)
+
+// idempotencyStore claims the key of each alarm by the conditional writes,
+// so that the redeliveries of SNS and the retries of lambda never post the same alarm twice.
+//
+// table schema:
+//   - partition key: "key" (S)
+//   - TTL attribute: "expires_at" (N), enable it to drop the old keys
+type idempotencyStore struct {
+	db    *dynamodb.DynamoDB
+	table string
+	ttl   time.Duration
+	by    string
+}
+
+func newIdempotencyStore(table string, ttl time.Duration, by string) *idempotencyStore {
+	return &idempotencyStore{
+		db:    dynamodb.New(awsSession()),
+		table: table,
+		ttl:   ttl,
+		by:    by,
+	}
+}
+
+// key is the SNS MessageId of the alarm, or the alarm, the state and the time of the change by IDEMPOTENCY_KEY.
+func (s *idempotencyStore) key(msg Alarm) string {
+	if s.by == idempotencyAlarm || msg.MessageID == "" {
+		name := msg.AlarmArn
+		if name == "" {
+			name = msg.AlarmName
+		}
+		return name + "/" + msg.NewStateValue + "/" + msg.StateChangeTime
+	}
+	return msg.MessageID
+}
+
+// claim reports whether the alarm is claimed first, false if it is claimed already (and not expired).
+func (s *idempotencyStore) claim(ctx context.Context, msg Alarm) (bool, error) {
+	return s.claimKey(ctx, s.key(msg), msg.AlarmName)
+}
+
+// claimKey claims the key, with the name of what is claimed for humans.
+func (s *idempotencyStore) claimKey(ctx context.Context, key, name string) (bool, error) {
+	now := time.Now()
+	_, err := s.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(s.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"key":        {S: aws.String(key)},
+			"alarm":      {S: aws.String(name)},
+			"expires_at": {N: aws.String(strconv.FormatInt(now.Add(s.ttl).Unix(), 10))},
+		},
+		// the TTL of DynamoDB may delete the expired items late
+		ConditionExpression:      aws.String("attribute_not_exists(#key) OR expires_at < :now"),
+		ExpressionAttributeNames: map[string]*string{"#key": aws.String("key")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// release deletes the key of the alarm not delivered, so that the retry of lambda can claim it again.
+func (s *idempotencyStore) release(ctx context.Context, msg Alarm) error {
+	return s.releaseKey(ctx, s.key(msg))
+}
+
+func (s *idempotencyStore) releaseKey(ctx context.Context, key string) error {
+	_, err := s.db.DeleteItemWithContext(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"key": {S: aws.String(key)},
+		},
+	})
+	return err
+}
@@ -0,0 +1,64 @@
+package cwa2mkr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// minNotificationInterval is the minimum of mackerel, less intervals are set to it by mackerel.
+const minNotificationInterval = 10
+
+// intervalRule sets the notificationInterval (minutes) of the reports of the alarms matching the glob pattern,
+// and of the status if set. the first matched rule is used, so put "*" last as the default.
+//
+//	[
+//	  {"alarm": "prod-*", "status": "CRITICAL", "interval": 30},
+//	  {"alarm": "*", "interval": 120}
+//	]
+type intervalRule struct {
+	Alarm    string `json:"alarm"`
+	Status   string `json:"status"`
+	Interval int    `json:"interval"`
+
+	alarm *regexp.Regexp
+}
+
+func parseIntervalRules(s string) ([]*intervalRule, error) {
+	var rules []*intervalRule
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, err
+	}
+	for i, r := range rules {
+		var err error
+		switch {
+		case r.Alarm == "":
+			err = errors.New("alarm is required")
+		case r.Interval < minNotificationInterval:
+			err = fmt.Errorf("interval must be %d or more", minNotificationInterval)
+		case r.Status != "":
+			if _, ok := statusSeverity[r.Status]; !ok {
+				err = fmt.Errorf("unknown status %q", r.Status)
+			}
+		}
+		if err == nil {
+			r.alarm, err = compileGlob(r.Alarm)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule[%d]: %s", i, err)
+		}
+	}
+	return rules, nil
+}
+
+// notificationInterval returns the interval of the first rule matching the alarm and the status,
+// or NOTIFICATION_INTERVAL (0 is not resending).
+func (c *config) notificationInterval(status string, msg Alarm) int {
+	for _, r := range c.intervalRules {
+		if r.alarm.MatchString(msg.AlarmName) && (r.Status == "" || r.Status == status) {
+			return r.Interval
+		}
+	}
+	return c.defaultInterval
+}
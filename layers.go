@@ -0,0 +1,222 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/apex/go-apex/sns"
+)
+
+// the layers of the settings, the later overrides the former:
+// the built-in defaults < CONFIG_FILE < the environment variables < TOPIC_SETTINGS of the topic < the SNS message attributes
+const (
+	layerFile      = "file"
+	layerEnv       = "env"
+	layerTopic     = "topic"
+	layerAttribute = "attribute"
+)
+
+// attributeSettings are the only settings overridden by the message attributes, which change only how the reports look.
+// anyone who can publish to the topics can set the attributes, so the credentials, the endpoints, the destinations
+// and the hosts are never overridden by them.
+var attributeSettings = map[string]bool{
+	"ENV_PREFIX":          true,
+	"MESSAGE_TEMPLATE":    true,
+	"MESSAGE_FIELDS":      true,
+	"MESSAGE_TRUNCATION":  true,
+	"CONSOLE_LINK":        true,
+	"STATE_TRANSITION":    true,
+	"TIMEZONE":            true,
+	"TIME_FORMAT":         true,
+	"CHECK_NAME_TEMPLATE": true,
+	"SLACK_TEMPLATE":      true,
+}
+
+// settingLayers looks up the settings through the layers.
+type settingLayers struct {
+	file      configFile
+	topic     map[string]string
+	attribute map[string]string
+}
+
+// lookup returns the value of the setting, and the layer of it.
+func (s settingLayers) lookup(name string) (string, string, bool) {
+	if v, ok := s.attribute[name]; ok {
+		return v, layerAttribute, true
+	}
+	if v, ok := s.topic[name]; ok {
+		return v, layerTopic, true
+	}
+	if v, ok := os.LookupEnv(name); ok {
+		return v, layerEnv, true
+	}
+	if v, ok := s.file[name]; ok {
+		return v, layerFile, true
+	}
+	return "", "", false
+}
+
+func (s settingLayers) getenv(name string) string {
+	v, _, _ := s.lookup(name)
+	return v
+}
+
+// overlaid reports whether the settings are overridden for the topic or the message.
+func (s settingLayers) overlaid() bool {
+	return len(s.topic) > 0 || len(s.attribute) > 0
+}
+
+// key identifies the overrides, to cache the forwarder built by them.
+func (s settingLayers) key() string {
+	b, _ := json.Marshal([]map[string]string{s.topic, s.attribute})
+	return string(b)
+}
+
+// parseTopicSettings parses TOPIC_SETTINGS, the settings for each SNS topic:
+//
+//	{"arn:aws:sns:ap-northeast-1:123456789012:prod-alarms": {"ENV_PREFIX": "[prod] ", "MESSAGE_FIELDS": "reason"}}
+func parseTopicSettings(s string) (map[string]map[string]string, error) {
+	var raw map[string]map[string]interface{}
+	if err := json.Unmarshal([]byte(s), &raw); err != nil {
+		return nil, err
+	}
+	topics := make(map[string]map[string]string, len(raw))
+	for topic, settings := range raw {
+		topics[topic] = make(map[string]string, len(settings))
+		for name, v := range settings {
+			s, err := settingString(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s: %s", topic, name, err)
+			}
+			topics[topic][strings.ToUpper(name)] = s
+		}
+	}
+	return topics, nil
+}
+
+var (
+	settingNamesOnce sync.Once
+	settingNamesList []string
+)
+
+// settingNames returns the names of all settings in config.schema.json.
+func settingNames() []string {
+	settingNamesOnce.Do(func() {
+		var schema jsonSchema
+		if err := json.Unmarshal(configSchema, &schema); err != nil {
+			return
+		}
+		for name := range schema.Properties {
+			settingNamesList = append(settingNamesList, name)
+		}
+		sort.Strings(settingNamesList)
+	})
+	return settingNamesList
+}
+
+// layersFor returns the layers of the settings for the SNS record of the payload, over the file.
+// the settings are not overlaid for the other events.
+func (c *config) layersFor(file configFile, payload []byte) settingLayers {
+	layers := settingLayers{file: file}
+	var e sns.Event
+	if err := json.Unmarshal(payload, &e); err != nil || len(e.Records) == 0 || e.Records[0].EventSource != "aws:sns" {
+		return layers
+	}
+	// SNS invokes with a record
+	record := e.Records[0]
+	layers.topic = c.topicSettings[record.SNS.TopicARN]
+
+	for name := range attributeSettings {
+		// like {"Type": "String", "Value": "..."}
+		attr, ok := record.SNS.MessageAttributes[name].(map[string]interface{})
+		if !ok || attr["Type"] != "String" {
+			continue
+		}
+		if v, ok := attr["Value"].(string); ok {
+			if layers.attribute == nil {
+				layers.attribute = make(map[string]string)
+			}
+			layers.attribute[name] = v
+		}
+	}
+	return layers
+}
+
+// maxOverlaidForwarders limits the forwarders cached for the overrides.
+const maxOverlaidForwarders = 100
+
+// forwarderFor returns the forwarder by the settings overridden for the payload, or f if not overridden.
+func (l *loader) forwarderFor(ctx context.Context, f *forwarder, payload []byte) (*forwarder, error) {
+	l.mu.Lock()
+	file := l.file
+	l.mu.Unlock()
+	layers := f.conf.layersFor(file, payload)
+	if !layers.overlaid() {
+		return f, nil
+	}
+
+	key := layers.key()
+	l.mu.Lock()
+	cached, ok := l.overlaid[key]
+	l.mu.Unlock()
+	if ok {
+		return cached, nil
+	}
+	of, err := l.configure(ctx, layers)
+	if err != nil {
+		return nil, err
+	}
+	l.mu.Lock()
+	if l.overlaid == nil || len(l.overlaid) >= maxOverlaidForwarders {
+		l.overlaid = make(map[string]*forwarder)
+	}
+	l.overlaid[key] = of
+	l.mu.Unlock()
+	return of, nil
+}
+
+// effectiveSetting is printed by -config flag.
+type effectiveSetting struct {
+	Value string `json:"value"`
+	Layer string `json:"layer"`
+}
+
+// printEffective prints the effective settings for the event read from r, with the layers of them.
+// the settings not printed are the built-in defaults.
+func (l *loader) printEffective(ctx context.Context, r io.Reader, w io.Writer) error {
+	payload, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	f, file := l.f, l.file
+	l.mu.Unlock()
+	layers := f.conf.layersFor(file, payload)
+
+	settings := make(map[string]effectiveSetting)
+	for _, name := range settingNames() {
+		v, layer, ok := layers.lookup(name)
+		if !ok {
+			continue
+		}
+		if secretSetting(name) && v != "" {
+			v = "****"
+		}
+		settings[name] = effectiveSetting{Value: v, Layer: layer}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(settings)
+}
+
+// secretSetting reports whether the setting should be masked.
+func secretSetting(name string) bool {
+	return strings.HasSuffix(name, "APIKEY") || strings.HasSuffix(name, "APIKEYS") || strings.HasSuffix(name, "_KEY") || strings.HasSuffix(name, "_ENCRYPTED")
+}
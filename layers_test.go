@@ -0,0 +1,89 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+)
+
+func snsPayload(t *testing.T, attributes map[string]string) []byte {
+	t.Helper()
+	attrs := make(map[string]interface{}, len(attributes))
+	for name, v := range attributes {
+		attrs[name] = map[string]interface{}{"Type": "String", "Value": v}
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"Records": []interface{}{
+			map[string]interface{}{
+				"EventSource": "aws:sns",
+				"Sns": map[string]interface{}{
+					"TopicArn":          "arn:aws:sns:ap-northeast-1:123456789012:alarms",
+					"Message":           "{}",
+					"MessageAttributes": attrs,
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestLayersForAttributes(t *testing.T) {
+	tests := []struct {
+		name       string
+		overridden bool
+	}{
+		{"ENV_PREFIX", true},
+		{"MESSAGE_FIELDS", true},
+		{"SLACK_TEMPLATE", true},
+		{"MACKEREL_APIKEY", false},
+		{"MACKEREL_APIURL", false},
+		{"DATADOG_SITE", false},
+		{"DATADOG_API_KEY", false},
+		{"OPSGENIE_API_URL", false},
+		{"WEBHOOKS", false},
+		{"SLACK_WEBHOOK_URL", false},
+		{"HOST_ID", false},
+		{"TOPIC_SETTINGS", false},
+	}
+	c := &config{}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layers := c.layersFor(nil, snsPayload(t, map[string]string{tt.name: "x"}))
+			_, layer, _ := layers.lookup(tt.name)
+			if got := layer == layerAttribute; got != tt.overridden {
+				t.Errorf("overridden = %v, want %v", got, tt.overridden)
+			}
+			if layers.overlaid() != tt.overridden {
+				t.Errorf("overlaid() = %v, want %v", layers.overlaid(), tt.overridden)
+			}
+		})
+	}
+}
+
+func TestResolvingSecretsFromOverrides(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers settingLayers
+	}{
+		{"topic", settingLayers{topic: map[string]string{"OPSGENIE_API_KEY": "secretsmanager:prod/db"}}},
+		{"attribute", settingLayers{attribute: map[string]string{"OPSGENIE_API_KEY": "secretsmanager:prod/db"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newSecretCache(defaultSecretsTTL)
+			get, errFn := c.resolving(context.Background(), tt.layers.lookup)
+			if v := get("OPSGENIE_API_KEY"); v != "" {
+				t.Errorf("got %q, want empty", v)
+			}
+			if err := errFn(); err == nil {
+				t.Error("want an error")
+			}
+			if len(c.values) != 0 {
+				t.Error("the secret is fetched")
+			}
+		})
+	}
+}
@@ -0,0 +1,242 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// loader builds the forwarder from the settings, and rebuilds it when the secrets are rotated
+// or CONFIG_FILE is changed (checked every CONFIG_RELOAD_SECONDS).
+type loader struct {
+	resolvers []SourceResolver
+	secrets   *secretCache
+
+	configFile string
+	source     configSource // if CONFIG_FILE is in AppConfig or DynamoDB
+	reloadTTL  time.Duration
+
+	mu       sync.Mutex
+	f        *forwarder
+	raw      []byte // the content of CONFIG_FILE loaded
+	file     configFile
+	loadedAt time.Time
+
+	// the forwarders by the settings overridden for the topics or the messages, see layers.go
+	overlaid map[string]*forwarder
+
+	// POST_RATE_LIMIT shared by the forwarders, by the rate
+	limiters map[int]*rateLimiter
+	// the circuit breakers shared by the forwarders, by MACKEREL_APIURL
+	breakers map[string]*circuitBreaker
+	// the counts of the failures shared by the forwarders, by OPS_TOPIC_ARN
+	opsAlerters map[string]*opsAlerter
+	// the ids of the pseudo hosts resolved once, by HOST_ID_PARAMETER and the API key
+	pseudoHosts map[string]string
+}
+
+func newLoader(resolvers []SourceResolver) (*loader, error) {
+	ttl := defaultSecretsTTL
+	if s := os.Getenv("SECRETS_TTL"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec <= 0 {
+			return nil, errors.New("SECRETS_TTL must be seconds")
+		}
+		ttl = time.Duration(sec) * time.Second
+	}
+	l := &loader{
+		resolvers:  resolvers,
+		secrets:    newSecretCache(ttl),
+		configFile: os.Getenv("CONFIG_FILE"),
+	}
+	switch {
+	case strings.HasPrefix(l.configFile, "appconfig:"):
+		s, err := newAppConfigSource(l.configFile)
+		if err != nil {
+			return nil, err
+		}
+		l.source, l.reloadTTL = s, defaultAppConfigReload
+	case strings.HasPrefix(l.configFile, "dynamodb:"):
+		s, err := newDynamoConfigSource(l.configFile)
+		if err != nil {
+			return nil, err
+		}
+		l.source, l.reloadTTL = s, defaultDynamoConfigReload
+	}
+	if s := os.Getenv("CONFIG_RELOAD_SECONDS"); s != "" {
+		sec, err := strconv.Atoi(s)
+		if err != nil || sec <= 0 {
+			return nil, errors.New("CONFIG_RELOAD_SECONDS must be seconds")
+		}
+		if l.configFile == "" {
+			return nil, errors.New("CONFIG_FILE is required to use CONFIG_RELOAD_SECONDS")
+		}
+		l.reloadTTL = time.Duration(sec) * time.Second
+	}
+	return l, nil
+}
+
+// load parses the settings, and builds the forwarder.
+func (l *loader) load(ctx context.Context) (*forwarder, error) {
+	var raw []byte
+	var file configFile
+	if l.configFile != "" {
+		var err error
+		if raw, err = l.read(ctx); err != nil {
+			return nil, fmt.Errorf("failed to read CONFIG_FILE: %s", err)
+		}
+		if file, err = parseConfigFile(raw); err != nil {
+			return nil, fmt.Errorf("CONFIG_FILE is invalid: %s", err)
+		}
+	}
+	return l.build(ctx, raw, file)
+}
+
+func (l *loader) build(ctx context.Context, raw []byte, file configFile) (*forwarder, error) {
+	f, err := l.configure(ctx, settingLayers{file: file})
+	if err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	coldStart := l.f == nil
+	l.mu.Unlock()
+	if err := f.validateAPIKeys(f.conf.withHTTP(ctx), coldStart); err != nil {
+		return nil, err
+	}
+
+	l.mu.Lock()
+	l.f = f
+	l.raw = raw
+	l.file = file
+	l.overlaid = nil
+	l.loadedAt = time.Now()
+	l.mu.Unlock()
+	return f, nil
+}
+
+// configure builds a forwarder from the settings of the layers.
+func (l *loader) configure(ctx context.Context, layers settingLayers) (*forwarder, error) {
+	conf, err := parseEnvVars(ctx, l.secrets, layers)
+	if err != nil {
+		return nil, err
+	}
+	conf.resolvers = append(append([]SourceResolver{}, l.resolvers...), conf.resolvers...)
+
+	if len(conf.hostIDs) == 0 {
+		hostID, err := l.pseudoHostID(conf.withHTTP(ctx), conf)
+		if err != nil {
+			return nil, err
+		}
+		conf.hostIDs = []string{hostID}
+	}
+	f := newForwarder(conf)
+	f.limiter = l.rateLimiter(conf.postRateLimit)
+	f.breaker = l.circuitBreaker(conf)
+	f.ops = l.opsAlerter(conf)
+	return f, nil
+}
+
+// configSource reads CONFIG_FILE from the services other than the file, S3 and SSM.
+type configSource interface {
+	read(ctx context.Context) ([]byte, error)
+}
+
+func (l *loader) read(ctx context.Context) ([]byte, error) {
+	if l.source != nil {
+		return l.source.read(ctx)
+	}
+	return readConfigFile(ctx, l.configFile)
+}
+
+// changed reads CONFIG_FILE again if CONFIG_RELOAD_SECONDS passed, and returns it if changed.
+func (l *loader) changed(ctx context.Context) ([]byte, error) {
+	l.mu.Lock()
+	expired := l.reloadTTL > 0 && time.Since(l.loadedAt) >= l.reloadTTL
+	prev := l.raw
+	l.mu.Unlock()
+	if !expired {
+		return nil, nil
+	}
+
+	raw, err := l.read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(raw, prev) {
+		l.mu.Lock()
+		l.loadedAt = time.Now()
+		l.mu.Unlock()
+		return nil, nil
+	}
+	return raw, nil
+}
+
+// current returns the forwarder, rebuilt if the settings are changed.
+// the previous one is kept if rebuilding fails.
+func (l *loader) current(ctx context.Context) *forwarder {
+	l.mu.Lock()
+	f := l.f
+	l.mu.Unlock()
+
+	raw, err := l.changed(ctx)
+	if err != nil {
+		log.Printf("failed to read CONFIG_FILE: %s", err)
+		return f
+	}
+	rotated, err := l.secrets.rotated(ctx)
+	if err != nil {
+		log.Printf("failed to refresh the secrets: %s", err)
+		return f
+	}
+	if raw == nil && !rotated {
+		return f
+	}
+
+	var nf *forwarder
+	if raw != nil {
+		log.Println("CONFIG_FILE is changed, so reload the settings")
+		var file configFile
+		if file, err = parseConfigFile(raw); err == nil {
+			nf, err = l.build(ctx, raw, file)
+		}
+	} else {
+		log.Println("the secrets are rotated, so reload the settings")
+		nf, err = l.load(ctx)
+	}
+	if err != nil {
+		log.Printf("failed to reload the settings: %s", err)
+		l.mu.Lock()
+		// not to retry every invocation
+		l.loadedAt = time.Now()
+		l.mu.Unlock()
+		return f
+	}
+	return nf
+}
+
+func (l *loader) handle(ctx context.Context, payload json.RawMessage) (interface{}, error) {
+	f := l.current(ctx)
+	if records := f.bufferedRecords(payload); records != nil {
+		resp, err := l.handleBuffered(ctx, f, records)
+		if resp == nil {
+			return nil, err
+		}
+		return resp, err
+	}
+	of, err := l.forwarderFor(ctx, f, payload)
+	if err != nil {
+		// the overrides may be broken by the publishers of the topics
+		log.Printf("failed to override the settings for the event, so use the settings as is: %s", err)
+		return f.handle(ctx, payload)
+	}
+	return of.handle(ctx, payload)
+}
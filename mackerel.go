@@ -0,0 +1,303 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+)
+
+const apiBaseURL = "https://api.mackerelio.com"
+
+// mackerelClient calls the mackerel APIs other than posting check reports.
+type mackerelClient struct {
+	apiKey  string
+	baseURL string
+}
+
+// newClient returns the client of the API of MACKEREL_APIURL.
+func (c *config) newClient(apiKey string) *mackerelClient {
+	return &mackerelClient{
+		apiKey:  apiKey,
+		baseURL: c.apiURL,
+	}
+}
+
+// do sends in as a JSON body (if not nil), and decodes the response into out (if not nil).
+func (c *mackerelClient) do(ctx context.Context, method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b := new(bytes.Buffer)
+		if err := json.NewEncoder(b).Encode(in); err != nil {
+			return err
+		}
+		body = b
+	}
+	req, cancel, err := newRequest(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	if in != nil {
+		req.Header.Set("Content-type", "application/json")
+	}
+	req.Header.Set("X-Api-Key", c.apiKey)
+	resp, err := sendRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if status := resp.StatusCode; status >= 400 {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response body: %s %s status code %d %s", method, path, status, err)
+		}
+		return &apiError{method: method, path: path, statusCode: status, body: string(body)}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type apiError struct {
+	method     string
+	path       string
+	statusCode int
+	body       string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("failed to %s %s: status code %d %s", e.method, e.path, e.statusCode, e.body)
+}
+
+func isNotFound(err error) bool {
+	e, ok := err.(*apiError)
+	return ok && e.statusCode == http.StatusNotFound
+}
+
+// https://mackerel.io/api-docs/entry/hosts
+type mackerelHost struct {
+	ID               string              `json:"id"`
+	Name             string              `json:"name"`
+	DisplayName      string              `json:"displayName"`
+	CustomIdentifier string              `json:"customIdentifier"`
+	Status           string              `json:"status"`
+	IsRetired        bool                `json:"isRetired"`
+	Roles            map[string][]string `json:"roles"`
+	Meta             hostMeta            `json:"meta"`
+}
+
+type hostMeta struct {
+	// set for the hosts registered by AWS integration
+	Cloud *struct {
+		Provider string `json:"provider"`
+	} `json:"cloud"`
+}
+
+// provider returns the cloud provider of the host like "rds", or empty.
+func (h mackerelHost) provider() string {
+	if h.Meta.Cloud == nil {
+		return ""
+	}
+	return h.Meta.Cloud.Provider
+}
+
+func (c *mackerelClient) getHost(ctx context.Context, hostID string) (*mackerelHost, error) {
+	var out struct {
+		Host *mackerelHost `json:"host"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v0/hosts/"+hostID, nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Host, nil
+}
+
+type createHostParam struct {
+	Name             string                 `json:"name"`
+	Meta             map[string]interface{} `json:"meta"`
+	CustomIdentifier string                 `json:"customIdentifier,omitempty"`
+	RoleFullnames    []string               `json:"roleFullnames,omitempty"`
+}
+
+// createHost registers a host, and returns its id.
+func (c *mackerelClient) createHost(ctx context.Context, param createHostParam) (string, error) {
+	if param.Meta == nil {
+		param.Meta = map[string]interface{}{}
+	}
+	var out struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v0/hosts", param, &out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+// retireHost retires the host, see https://mackerel.io/api-docs/entry/hosts#retire
+func (c *mackerelClient) retireHost(ctx context.Context, hostID string) error {
+	return c.do(ctx, http.MethodPost, "/api/v0/hosts/"+hostID+"/retire", map[string]string{}, nil)
+}
+
+// findHosts searches hosts by the query, see https://mackerel.io/api-docs/entry/hosts#list
+func (c *mackerelClient) findHosts(ctx context.Context, query url.Values) ([]mackerelHost, error) {
+	var out struct {
+		Hosts []mackerelHost `json:"hosts"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v0/hosts?"+query.Encode(), nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Hosts, nil
+}
+
+// https://mackerel.io/api-docs/entry/graph-annotations
+type graphAnnotation struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description,omitempty"`
+	From        int64    `json:"from"`
+	To          int64    `json:"to"`
+	Service     string   `json:"service"`
+	Roles       []string `json:"roles,omitempty"`
+}
+
+func (c *mackerelClient) createGraphAnnotation(ctx context.Context, a graphAnnotation) error {
+	return c.do(ctx, http.MethodPost, "/api/v0/graph-annotations", a, nil)
+}
+
+// https://mackerel.io/api-docs/entry/service-metrics
+type metricValue struct {
+	Name  string  `json:"name"`
+	Time  int64   `json:"time"`
+	Value float64 `json:"value"`
+}
+
+func (c *mackerelClient) postServiceMetrics(ctx context.Context, service string, values []metricValue) error {
+	return c.do(ctx, http.MethodPost, "/api/v0/services/"+url.PathEscape(service)+"/tsdb", values, nil)
+}
+
+// https://mackerel.io/api-docs/entry/host-metrics
+type hostMetricValue struct {
+	HostID string  `json:"hostId"`
+	Name   string  `json:"name"`
+	Time   int64   `json:"time"`
+	Value  float64 `json:"value"`
+}
+
+func (c *mackerelClient) postHostMetrics(ctx context.Context, values []hostMetricValue) error {
+	return c.do(ctx, http.MethodPost, "/api/v0/tsdb", values, nil)
+}
+
+// https://mackerel.io/api-docs/entry/alerts
+type mackerelAlert struct {
+	ID        string `json:"id"`
+	Status    string `json:"status"`
+	MonitorID string `json:"monitorId"`
+	Type      string `json:"type"`
+	HostID    string `json:"hostId"`
+	Message   string `json:"message"`
+	OpenedAt  int64  `json:"openedAt"`
+}
+
+// openAlerts returns all open alerts.
+func (c *mackerelClient) openAlerts(ctx context.Context) ([]mackerelAlert, error) {
+	var alerts []mackerelAlert
+	query := url.Values{"limit": {"100"}}
+	for {
+		var out struct {
+			Alerts []mackerelAlert `json:"alerts"`
+			NextID string          `json:"nextId"`
+		}
+		if err := c.do(ctx, http.MethodGet, "/api/v0/alerts?"+query.Encode(), nil, &out); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, out.Alerts...)
+		if out.NextID == "" {
+			return alerts, nil
+		}
+		query.Set("nextId", out.NextID)
+	}
+}
+
+func (c *mackerelClient) closeAlert(ctx context.Context, alertID, reason string) error {
+	return c.do(ctx, http.MethodPost, "/api/v0/alerts/"+alertID+"/close", map[string]string{"reason": reason}, nil)
+}
+
+// https://mackerel.io/api-docs/entry/monitors
+type mackerelMonitor struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// listMonitors returns the names of the monitors by the ids.
+func (c *mackerelClient) listMonitors(ctx context.Context) (map[string]string, error) {
+	var out struct {
+		Monitors []mackerelMonitor `json:"monitors"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v0/monitors", nil, &out); err != nil {
+		return nil, err
+	}
+	names := make(map[string]string, len(out.Monitors))
+	for _, m := range out.Monitors {
+		names[m.ID] = m.Name
+	}
+	return names, nil
+}
+
+// getDashboard returns the dashboard as is, not to lose the fields unknown here when updating.
+// https://mackerel.io/api-docs/entry/dashboards
+func (c *mackerelClient) getDashboard(ctx context.Context, dashboardID string) (map[string]interface{}, error) {
+	var out map[string]interface{}
+	if err := c.do(ctx, http.MethodGet, "/api/v0/dashboards/"+dashboardID, nil, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *mackerelClient) updateDashboard(ctx context.Context, dashboardID string, dashboard map[string]interface{}) error {
+	return c.do(ctx, http.MethodPut, "/api/v0/dashboards/"+dashboardID, dashboard, nil)
+}
+
+func (c *mackerelClient) listDowntimes(ctx context.Context) ([]downtime, error) {
+	var out struct {
+		Downtimes []downtime `json:"downtimes"`
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v0/downtimes", nil, &out); err != nil {
+		return nil, err
+	}
+	return out.Downtimes, nil
+}
+
+func (c *mackerelClient) createDowntime(ctx context.Context, d downtime) (string, error) {
+	var out struct {
+		ID string `json:"id"`
+	}
+	if err := c.do(ctx, http.MethodPost, "/api/v0/downtimes", d, &out); err != nil {
+		return "", err
+	}
+	return out.ID, nil
+}
+
+func (c *mackerelClient) deleteDowntime(ctx context.Context, downtimeID string) error {
+	return c.do(ctx, http.MethodDelete, "/api/v0/downtimes/"+downtimeID, nil, nil)
+}
+
+// https://mackerel.io/api-docs/entry/organizations
+type mackerelOrg struct {
+	Name string `json:"name"`
+}
+
+func (c *mackerelClient) getOrg(ctx context.Context) (*mackerelOrg, error) {
+	var out mackerelOrg
+	if err := c.do(ctx, http.MethodGet, "/api/v0/org", nil, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
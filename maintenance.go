@@ -0,0 +1,137 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"time"
+)
+
+const (
+	defaultMaintenanceMinutes = 60
+	maintenanceDowntimePrefix = "cloudwatch-alarm: "
+)
+
+// maintenance creates a mackerel downtime of the roles of the hosts instead of reporting the alarm,
+// for the alarms signaling planned maintenance. the downtime is deleted when the alarm gets OK.
+type maintenance struct {
+	alarms   *regexp.Regexp
+	tag      string
+	tags     *tagCache
+	duration time.Duration
+}
+
+// is reports whether the alarm is for maintenance, by MAINTENANCE_ALARMS or MAINTENANCE_TAG.
+func (m *maintenance) is(ctx context.Context, msg Alarm) (bool, error) {
+	if m.alarms != nil && m.alarms.MatchString(msg.AlarmName) {
+		return true, nil
+	}
+	if m.tag == "" || msg.AlarmArn == "" {
+		return false, nil
+	}
+	tags, err := m.tags.get(ctx, msg.AlarmArn)
+	if err != nil {
+		return false, fmt.Errorf("failed to get tags of %s: %s", msg.AlarmArn, err)
+	}
+	_, ok := tags[m.tag]
+	return ok, nil
+}
+
+// maintain starts or ends the downtime of the alarm for the hosts of the alarm, in each organization of the hosts.
+func (f *forwarder) maintain(ctx context.Context, msg Alarm) error {
+	sources, err := f.conf.resolveSources(ctx, msg)
+	if err != nil {
+		return err
+	}
+	if len(sources) == 0 {
+		log.Printf("skip the maintenance alarm %s, because no host is resolved", msg.AlarmName)
+		return nil
+	}
+
+	// the hosts by the API keys, in the order of the sources
+	var clients []*mackerelClient
+	hosts := make(map[*mackerelClient][]Source)
+	for _, src := range sources {
+		client := f.clientFor(src)
+		if _, ok := hosts[client]; !ok {
+			clients = append(clients, client)
+		}
+		hosts[client] = append(hosts[client], src)
+	}
+	var errs batchErrors
+	for _, client := range clients {
+		if err := f.maintainOrg(ctx, client, hosts[client], msg); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
+}
+
+// maintainOrg starts or ends the downtime of the alarm in an organization.
+// the downtime existing is kept, not to create another one by the notifications delivered again.
+func (f *forwarder) maintainOrg(ctx context.Context, client *mackerelClient, sources []Source, msg Alarm) error {
+	name := maintenanceDowntimePrefix + msg.AlarmName
+	list, err := client.listDowntimes(ctx)
+	if err != nil {
+		return err
+	}
+
+	if msg.NewStateValue != "ALARM" {
+		for _, d := range list {
+			if d.Name != name {
+				continue
+			}
+			if err := client.deleteDowntime(ctx, d.ID); err != nil {
+				return err
+			}
+			log.Printf("deleted the downtime %s for %s", d.ID, msg.AlarmName)
+		}
+		return nil
+	}
+
+	now := time.Now()
+	for _, d := range list {
+		if d.Name == name && d.activeAt(now) {
+			log.Printf("skip the maintenance alarm %s, because the downtime %s exists", msg.AlarmName, d.ID)
+			return nil
+		}
+	}
+
+	// a downtime can not be scoped to hosts, so to the roles of them
+	scopes := make(map[string]bool)
+	for _, src := range sources {
+		host, err := client.getHost(ctx, src.HostID)
+		if err != nil {
+			return err
+		}
+		for service, roles := range host.Roles {
+			for _, role := range roles {
+				scopes[service+": "+role] = true
+			}
+		}
+	}
+	if len(scopes) == 0 {
+		// empty scopes mean the whole organization
+		log.Printf("skip the maintenance alarm %s, because the hosts have no roles", msg.AlarmName)
+		return nil
+	}
+	roleScopes := make([]string, 0, len(scopes))
+	for scope := range scopes {
+		roleScopes = append(roleScopes, scope)
+	}
+	sort.Strings(roleScopes)
+
+	id, err := client.createDowntime(ctx, downtime{
+		Name:       name,
+		Start:      now.Unix(),
+		Duration:   int64(f.conf.maintenance.duration / time.Minute),
+		RoleScopes: roleScopes,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("created the downtime %s of %v for %s", id, roleScopes, msg.AlarmName)
+	return nil
+}
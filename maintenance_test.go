@@ -0,0 +1,52 @@
+package cwa2mkr
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMaintain(t *testing.T) {
+	now := time.Now().Unix()
+	tests := []struct {
+		name      string
+		state     string
+		downtimes []downtime
+		created   int
+		deleted   int
+	}{
+		{"start", "ALARM", nil, 1, 0},
+		{"delivered again", "ALARM", []downtime{{ID: "d1", Name: maintenanceDowntimePrefix + "maintenance", Start: now - 60, Duration: 60}}, 0, 0},
+		{"expired", "ALARM", []downtime{{ID: "d1", Name: maintenanceDowntimePrefix + "maintenance", Start: now - 7200, Duration: 60}}, 1, 0},
+		{"another downtime", "ALARM", []downtime{{ID: "d1", Name: "deploy", Start: now - 60, Duration: 60}}, 1, 0},
+		{"end", "OK", []downtime{{ID: "d1", Name: maintenanceDowntimePrefix + "maintenance", Start: now - 60, Duration: 60}}, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mackerel := newFakeServer(t, func(call fakeCall) (int, interface{}) {
+				switch call.op {
+				case "GET /api/v0/downtimes":
+					return 200, map[string]interface{}{"downtimes": tt.downtimes}
+				case "GET /api/v0/hosts/host":
+					return 200, map[string]interface{}{"host": map[string]interface{}{"id": "host", "roles": map[string][]string{"service": {"role"}}}}
+				}
+				return 0, nil
+			})
+			f := testForwarder(t, mackerel, map[string]string{"MAINTENANCE_ALARMS": "maintenance"})
+
+			payload, event := testEvent(t, testAlarm("maintenance", tt.state))
+			if err := f.handleSNS(context.Background(), payload, event); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(mackerel.called("POST /api/v0/monitoring/checks/report", "")); n != 0 {
+				t.Errorf("reported the maintenance alarm %d times", n)
+			}
+			if n := len(mackerel.called("POST /api/v0/downtimes", "")); n != tt.created {
+				t.Errorf("created %d downtimes, want %d", n, tt.created)
+			}
+			if n := len(mackerel.called("DELETE /api/v0/downtimes/d1", "")); n != tt.deleted {
+				t.Errorf("deleted %d downtimes, want %d", n, tt.deleted)
+			}
+		})
+	}
+}
@@ -0,0 +1,250 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+)
+
+// messageData is passed to MESSAGE_TEMPLATE.
+type messageData struct {
+	Alarm
+
+	// the status reported
+	Status string
+
+	// the code of the region like "ap-northeast-1" from AlarmArn, Region is the name like "Asia Pacific (Tokyo)"
+	RegionCode string
+
+	// the URL of the alarm in the CloudWatch console
+	ConsoleURL string
+
+	// the transition of the state like "OK → ALARM", or NewStateValue if OldStateValue is unknown
+	Transition string
+}
+
+var messageFuncs = template.FuncMap{
+	"dimensions": formatDimensions,
+}
+
+// formatDimensions renders the dimensions like "Name=Value, Name=Value".
+func formatDimensions(ds []Dimension) string {
+	var b bytes.Buffer
+	for i, d := range ds {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s=%s", d.Name, d.Value)
+	}
+	return b.String()
+}
+
+func parseMessageTemplate(text string) (*template.Template, error) {
+	return template.New("message").Funcs(messageFuncs).Parse(text)
+}
+
+// maxMessageLength is the limit of the message of a report, in characters.
+const maxMessageLength = 1024
+
+// strategies to truncate a long message, by MESSAGE_TRUNCATION
+const (
+	truncateHead     = "head"      // keep the head (default)
+	truncateHeadTail = "head_tail" // keep the head and the tail
+	truncateFields   = "fields"    // drop the less important fields first, then keep the head
+)
+
+const ellipsis = "..."
+
+// fields of the message in order, selected by MESSAGE_FIELDS.
+var messageFields = []string{"reason", "description", "state_change_time", "metrics", "namespace", "dimensions", "account", "region", "service", "role"}
+
+// defaultMessageFields are the fields of reportMsgFmt, and service and role by RESOURCE_TAGS.
+var defaultMessageFields = []string{"reason", "description", "state_change_time", "metrics", "namespace", "service", "role"}
+
+// droppableFields of the message, the least important first. the reason is never dropped.
+var droppableFields = []string{"description", "state_change_time", "namespace", "metrics", "dimensions", "account", "region", "role", "service"}
+
+func parseMessageFields(s string) ([]string, error) {
+	selected := make(map[string]bool)
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if !contains(messageFields, name) {
+			return nil, fmt.Errorf("unknown field %q, must be one of %s", name, strings.Join(messageFields, ", "))
+		}
+		selected[name] = true
+	}
+	fields := make([]string, 0, len(selected))
+	for _, name := range messageFields {
+		if selected[name] {
+			fields = append(fields, name)
+		}
+	}
+	return fields, nil
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// message renders the message of the report, by MESSAGE_TEMPLATE or the fields of MESSAGE_FIELDS, after head and followed by note.
+// the message is truncated to maxMessageLength by MESSAGE_TRUNCATION, but head and the links to the console by CONSOLE_LINK and the runbook are kept.
+func (c *config) message(msg Alarm, status, head, note string) (string, error) {
+	var link string
+	if c.consoleLink {
+		if u := msg.consoleURL(); u != "" {
+			link = " " + u
+		}
+	}
+	if msg.Runbook != "" {
+		link += " runbook: " + msg.Runbook
+	}
+	limit := maxMessageLength - utf8.RuneCountInString(head) - utf8.RuneCountInString(link)
+	if limit <= len(ellipsis) {
+		link, limit = "", maxMessageLength-utf8.RuneCountInString(head)
+	}
+
+	m, err := c.truncatedMessage(msg, status, note, limit)
+	if err != nil {
+		return "", err
+	}
+	return head + m + link, nil
+}
+
+// truncatedMessage renders the message truncated to limit characters.
+func (c *config) truncatedMessage(msg Alarm, status, note string, limit int) (string, error) {
+	msg.StateChangeTime = c.formatTime(msg)
+
+	var m string
+	if c.messageTemplate == nil {
+		m = c.defaultMessage(msg, 0) + note
+	} else {
+		region, _ := alarmRegionAccount(msg.AlarmArn)
+		var b bytes.Buffer
+		data := messageData{Alarm: msg, Status: status, RegionCode: region, ConsoleURL: msg.consoleURL(), Transition: msg.transition()}
+		if err := c.messageTemplate.Execute(&b, data); err != nil {
+			return "", err
+		}
+		m = b.String() + note
+	}
+
+	length := utf8.RuneCountInString(m)
+	if length <= limit {
+		return m, nil
+	}
+	log.Printf("the message of %s is truncated from %d to %d characters by %s", msg.AlarmName, length, limit, c.truncation())
+
+	switch c.truncation() {
+	case truncateHeadTail:
+		r := []rune(m)
+		head := (limit - len(ellipsis)) / 2
+		tail := limit - len(ellipsis) - head
+		return string(r[:head]) + ellipsis + string(r[len(r)-tail:]), nil
+	case truncateFields:
+		if c.messageTemplate == nil {
+			for drop := 1; drop <= len(droppableFields); drop++ {
+				if m = c.defaultMessage(msg, drop) + note; utf8.RuneCountInString(m) <= limit {
+					return m, nil
+				}
+			}
+		}
+	}
+	r := []rune(m)
+	return string(r[:limit-len(ellipsis)]) + ellipsis, nil
+}
+
+// state renders the state in the message, with the transition by STATE_TRANSITION.
+func (c *config) state(msg Alarm) string {
+	if c.stateTransition {
+		return msg.transition()
+	}
+	return msg.NewStateValue
+}
+
+// transition returns like "OK → ALARM", or NewStateValue if OldStateValue is unknown.
+func (m Alarm) transition() string {
+	if m.OldStateValue == "" {
+		return m.NewStateValue
+	}
+	return m.OldStateValue + " → " + m.NewStateValue
+}
+
+func (c *config) truncation() string {
+	if c.messageTruncation == "" {
+		return truncateHead
+	}
+	return c.messageTruncation
+}
+
+// defaultMessage renders the fields of the message, without the first drop fields of droppableFields.
+// it is same as reportMsgFmt by default.
+func (c *config) defaultMessage(msg Alarm, drop int) string {
+	if c.messageFields == nil && drop == 0 {
+		m := fmt.Sprintf(reportMsgFmt,
+			msg.AlarmName,
+			c.state(msg),
+			msg.NewStateReason,
+			msg.AlarmDescription,
+			msg.StateChangeTime,
+			msg.Trigger.MetricName,
+			msg.Trigger.Namespace,
+		)
+		if msg.Service != "" {
+			m += ", service: " + msg.Service
+			if msg.Role != "" {
+				m += ", role: " + msg.Role
+			}
+		}
+		return m
+	}
+
+	fields := c.messageFields
+	if fields == nil {
+		fields = defaultMessageFields
+	}
+	dropped := droppableFields[:drop]
+	m := fmt.Sprintf("%s status is '%s'", msg.AlarmName, c.state(msg))
+	for _, name := range fields {
+		if contains(dropped, name) {
+			continue
+		}
+		label, value := name, ""
+		switch name {
+		case "reason":
+			value = msg.NewStateReason
+		case "description":
+			label, value = "alarm_description", msg.AlarmDescription
+		case "state_change_time":
+			value = msg.StateChangeTime
+		case "metrics":
+			value = msg.Trigger.MetricName
+		case "namespace":
+			value = msg.Trigger.Namespace
+		case "dimensions":
+			value = formatDimensions(msg.Trigger.Dimensions)
+		case "account":
+			value = msg.AWSAccountID
+		case "region":
+			if value, _ = alarmRegionAccount(msg.AlarmArn); value == "" {
+				value = msg.Region
+			}
+		case "service", "role":
+			// only if tagged by RESOURCE_TAGS
+			if value = msg.Service; name == "role" {
+				value = msg.Role
+			}
+			if value == "" {
+				continue
+			}
+		}
+		m += ", " + label + ": " + value
+	}
+	return m
+}
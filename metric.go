@@ -0,0 +1,53 @@
+package cwa2mkr
+
+import (
+	"context"
+	"regexp"
+)
+
+// statusMetricValues are the values of metrics representing the statuses.
+var statusMetricValues = map[string]float64{
+	StatusOK:       0,
+	StatusWarning:  1,
+	StatusCritical: 2,
+	StatusUnknown:  3,
+}
+
+var invalidMetricNameChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// alarmMetricName returns the metric name for the check, like "cloudwatch.alarm.my_alarm".
+func alarmMetricName(name string) string {
+	return "cloudwatch.alarm." + invalidMetricNameChars.ReplaceAllString(name, "_")
+}
+
+// postStateMetric posts the status of the check as a service metric of STATE_METRIC_SERVICE.
+func (f *forwarder) postStateMetric(ctx context.Context, rep Report) error {
+	return f.client.postServiceMetrics(ctx, f.conf.stateMetricService, []metricValue{
+		{
+			Name:  alarmMetricName(rep.Name),
+			Time:  rep.OccurredAt,
+			Value: statusMetricValues[rep.Status],
+		},
+	})
+}
+
+// postHostStateMetrics posts the statuses of the checks as host metrics of their hosts.
+// custom host metrics must be prefixed by "custom.", like "custom.cloudwatch.alarm.my_alarm".
+func (f *forwarder) postHostStateMetrics(ctx context.Context, reps []Report) error {
+	byClient := make(map[*mackerelClient][]hostMetricValue)
+	for _, rep := range reps {
+		c := f.clientFor(rep.Source)
+		byClient[c] = append(byClient[c], hostMetricValue{
+			HostID: rep.Source.HostID,
+			Name:   "custom." + alarmMetricName(rep.Name),
+			Time:   rep.OccurredAt,
+			Value:  statusMetricValues[rep.Status],
+		})
+	}
+	for c, values := range byClient {
+		if err := c.postHostMetrics(ctx, values); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package cwa2mkr
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// mirrorOrg is another organization to post all reports, with its own host.
+//
+//	[
+//	  {"api_key": "xxx", "host_id": "hostX"},
+//	  {"credential": "dev", "host_id": "hostY"}
+//	]
+type mirrorOrg struct {
+	APIKey     string `json:"api_key"`
+	Credential string `json:"credential"`
+	HostID     string `json:"host_id"`
+}
+
+func parseMirrorOrgs(s string, creds credentials) ([]mirrorOrg, error) {
+	var orgs []mirrorOrg
+	if err := json.Unmarshal([]byte(s), &orgs); err != nil {
+		return nil, err
+	}
+	for i := range orgs {
+		o := &orgs[i]
+		var err error
+		if o.APIKey, err = creds.apiKey(o.APIKey, o.Credential); err != nil {
+			return nil, fmt.Errorf("org[%d]: %s", i, err)
+		}
+		if o.APIKey == "" || o.HostID == "" {
+			return nil, fmt.Errorf("org[%d]: api_key (or credential) and host_id are required", i)
+		}
+	}
+	return orgs, nil
+}
+
+// mirror returns the reports with the copies for MIRROR_ORGS.
+// the same check is reported once to each mirror host, even if it is fanned out to several hosts.
+func (c *config) mirror(reps Reports) Reports {
+	if len(c.mirrorOrgs) == 0 {
+		return reps
+	}
+	mirrored := Reports{Reports: append([]Report{}, reps.Reports...)}
+	for _, org := range c.mirrorOrgs {
+		seen := make(map[string]bool)
+		for _, rep := range reps.Reports {
+			if seen[rep.Name] {
+				continue
+			}
+			seen[rep.Name] = true
+			rep.Source = Source{Type: "host", HostID: org.HostID, apiKey: org.APIKey}
+			mirrored.Reports = append(mirrored.Reports, rep)
+		}
+	}
+	return mirrored
+}
@@ -0,0 +1,120 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Notifier is an output of the reports along with mackerel, like Slack or webhooks.
+type Notifier interface {
+	Notify(ctx context.Context, rep Report) error
+}
+
+// NotifierFunc adapts a function to Notifier.
+type NotifierFunc func(ctx context.Context, rep Report) error
+
+func (fn NotifierFunc) Notify(ctx context.Context, rep Report) error {
+	return fn(ctx, rep)
+}
+
+// notifier is a Notifier registered with its name.
+type notifier struct {
+	name string
+	Notifier
+
+	// notified only the reports failed to post to mackerel
+	onlyFailure bool
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []notifier
+)
+
+// RegisterNotifier adds the notifier of every report, along with the ones configured by environment variables.
+// it should be called before ApexRun.
+func RegisterNotifier(name string, n Notifier) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry = append(registry, notifier{name: name, Notifier: n})
+}
+
+// registeredNotifiers returns the notifiers configured and registered.
+func (c *config) registeredNotifiers() []notifier {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	return append(append([]notifier{}, c.notifiers...), registry...)
+}
+
+const (
+	// tries to send to an output
+	sendAttempts = 3
+	sendInterval = 500 * time.Millisecond
+	sendMaxWait  = 10 * time.Second
+)
+
+// permanentError is not retried.
+type permanentError struct {
+	error
+}
+
+// notifyErrors aggregates the errors of the notifiers.
+type notifyErrors []error
+
+func (e notifyErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// notifyAll sends the reports to the notifiers concurrently, and the failed ones to the notifiers only of the failures.
+func notifyAll(ctx context.Context, notifiers []notifier, reps, failed []Report) error {
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs notifyErrors
+	)
+	for _, n := range notifiers {
+		reps := reps
+		if n.onlyFailure {
+			reps = failed
+		}
+		if len(reps) == 0 {
+			continue
+		}
+		wg.Add(1)
+		go func(n notifier, reps []Report) {
+			defer wg.Done()
+			for _, rep := range reps {
+				if _, err := retry(ctx, sendAttempts, func() error { return n.Notify(ctx, rep) }); err != nil {
+					mu.Lock()
+					errs = append(errs, fmt.Errorf("failed to notify %s to %s: %s", rep.Name, n.name, err))
+					mu.Unlock()
+				}
+			}
+		}(n, reps)
+	}
+	wg.Wait()
+
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// logNotifyErrors logs each error of notifyAll.
+func logNotifyErrors(err error) {
+	if errs, ok := err.(notifyErrors); ok {
+		for _, err := range errs {
+			log.Println(err)
+		}
+	} else if err != nil {
+		log.Println(err)
+	}
+}
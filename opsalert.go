@@ -0,0 +1,112 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+const (
+	defaultOpsAlertFailures = 3
+	// the alert is published by its own timeout, since the failure may be the deadline of the invocation
+	opsAlertTimeout = 5 * time.Second
+)
+
+// opsAlert is published to OPS_TOPIC_ARN, so that humans learn the forwarder itself is broken before the checks go stale.
+type opsAlert struct {
+	Source              string    `json:"source"`
+	Function            string    `json:"function"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Reports             int       `json:"reports"`
+	Error               string    `json:"error"`
+	At                  time.Time `json:"at"`
+}
+
+// opsAlerter counts the consecutive failures to deliver, and alerts once after OPS_ALERT_FAILURES of them until a delivery succeeds.
+// the count is kept while the lambda container is warm, and shared by the forwarders alerting to the same topic like the circuit breaker. nil does nothing.
+type opsAlerter struct {
+	sns       *sns.SNS
+	topic     string
+	threshold int
+
+	mu       sync.Mutex
+	failures int
+}
+
+func newOpsAlerter(topic string, threshold int) *opsAlerter {
+	return &opsAlerter{
+		sns:       sns.New(awsSession()),
+		topic:     topic,
+		threshold: threshold,
+	}
+}
+
+// opsAlerter returns the alerter of OPS_TOPIC_ARN shared by the forwarders, even reloaded, or nil not to alert.
+// the threshold is updated by the latest settings.
+func (l *loader) opsAlerter(conf *config) *opsAlerter {
+	if conf.opsTopic == "" {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.opsAlerters == nil {
+		l.opsAlerters = make(map[string]*opsAlerter)
+	}
+	a := l.opsAlerters[conf.opsTopic]
+	if a == nil {
+		a = newOpsAlerter(conf.opsTopic, conf.opsAlertFailures)
+		l.opsAlerters[conf.opsTopic] = a
+		return a
+	}
+	a.mu.Lock()
+	a.threshold = conf.opsAlertFailures
+	a.mu.Unlock()
+	return a
+}
+
+// record counts the result of a delivery. failed is the number of the reports not delivered.
+func (a *opsAlerter) record(failed int, err error) {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if err == nil {
+		a.failures = 0
+		a.mu.Unlock()
+		return
+	}
+	a.failures++
+	n, threshold := a.failures, a.threshold
+	a.mu.Unlock()
+	if n != threshold {
+		return
+	}
+
+	alert := opsAlert{
+		Source:              "cloudwatch-alarm-to-mackerel",
+		Function:            os.Getenv("AWS_LAMBDA_FUNCTION_NAME"),
+		ConsecutiveFailures: n,
+		Reports:             failed,
+		Error:               err.Error(),
+		At:                  time.Now(),
+	}
+	b, _ := json.Marshal(alert)
+	ctx, cancel := context.WithTimeout(context.Background(), opsAlertTimeout)
+	defer cancel()
+	out, perr := a.sns.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn: aws.String(a.topic),
+		Subject:  aws.String("cloudwatch-alarm-to-mackerel failed to deliver to mackerel"),
+		Message:  aws.String(string(b)),
+	})
+	if perr != nil {
+		log.Printf("failed to alert the operators: %s", perr)
+		return
+	}
+	log.Printf("alerted the operators after %d consecutive failures: %s", n, aws.StringValue(out.MessageId))
+}
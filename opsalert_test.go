@@ -0,0 +1,21 @@
+package cwa2mkr
+
+import "testing"
+
+func TestLoaderSharesOpsAlerter(t *testing.T) {
+	useFakeAWS(t, newFakeServer(t, nil))
+	l := &loader{}
+	a := l.opsAlerter(&config{opsTopic: "arn:aws:sns:ap-northeast-1:123456789012:ops", opsAlertFailures: 3})
+	if got := l.opsAlerter(&config{opsTopic: a.topic, opsAlertFailures: 5}); got != a {
+		t.Error("another alerter for the same topic")
+	}
+	if a.threshold != 5 {
+		t.Errorf("threshold %d, want the latest 5", a.threshold)
+	}
+	if l.opsAlerter(&config{opsTopic: "arn:aws:sns:ap-northeast-1:123456789012:other", opsAlertFailures: 3}) == a {
+		t.Error("the alerter shared by the other topic")
+	}
+	if l.opsAlerter(&config{}) != nil {
+		t.Error("an alerter without OPS_TOPIC_ARN")
+	}
+}
@@ -0,0 +1,56 @@
+package cwa2mkr
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+)
+
+const defaultOpsgenieAPIURL = "https://api.opsgenie.com"
+
+// opsgeniePriorities maps the mackerel status to the priority of Opsgenie alerts.
+var opsgeniePriorities = map[string]string{
+	StatusCritical: "P1",
+	StatusWarning:  "P3",
+	StatusUnknown:  "P4",
+}
+
+// opsgenieNotifier creates the Opsgenie alerts for the reports, and closes them on OK.
+// https://docs.opsgenie.com/docs/alert-api
+type opsgenieNotifier struct {
+	apiURL string
+	header http.Header
+}
+
+func newOpsgenieNotifier(apiKey, apiURL string) *opsgenieNotifier {
+	if apiURL == "" {
+		apiURL = defaultOpsgenieAPIURL
+	}
+	header := make(http.Header)
+	header.Set("Authorization", "GenieKey "+apiKey)
+	return &opsgenieNotifier{apiURL: apiURL, header: header}
+}
+
+func (o *opsgenieNotifier) Notify(ctx context.Context, rep Report) error {
+	// the same alert is identified by the alias
+	alias := rep.Name + "/" + rep.Source.HostID
+	if rep.Status == StatusOK {
+		return postJSON(ctx, o.apiURL+"/v2/alerts/"+url.PathEscape(alias)+"/close?identifierType=alias", map[string]string{
+			"source": "cloudwatch-alarm-to-mackerel",
+			"note":   rep.Message,
+		}, o.header)
+	}
+
+	message := rep.Status + " " + rep.Name
+	// the limit of message
+	if r := []rune(message); len(r) > 130 {
+		message = string(r[:130])
+	}
+	return postJSON(ctx, o.apiURL+"/v2/alerts", map[string]string{
+		"message":     message,
+		"alias":       alias,
+		"description": rep.Message,
+		"priority":    opsgeniePriorities[rep.Status],
+		"source":      "cloudwatch-alarm-to-mackerel",
+	}, o.header)
+}
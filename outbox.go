@@ -0,0 +1,208 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-lambda-go/lambdacontext"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+const (
+	// status of the outbox items
+	outboxPending   = "pending"
+	outboxDelivered = "delivered"
+	outboxDead      = "dead"
+
+	defaultOutboxMaxAttempts = 5
+
+	// the pending items younger than it may be being posted by the invocation which wrote them
+	outboxGrace = 5 * time.Minute
+	// the delivered and dead items are kept for it, by the TTL of DynamoDB
+	outboxRetention = 7 * 24 * time.Hour
+	// the items drained in an invocation
+	maxOutboxDrain = 100
+)
+
+// outbox writes the reports to DynamoDB before posting and marks them delivered after posted,
+// so that the reports survive the crashes of lambda between parsing and posting.
+// the pending ones are posted again by {"action": "drain_outbox"} of an EventBridge schedule rule.
+//
+// table schema:
+//   - partition key: "id" (S)
+//   - TTL attribute: "expires_at" (N), enable it to drop the delivered items
+type outbox struct {
+	db          *dynamodb.DynamoDB
+	table       string
+	maxAttempts int
+}
+
+// outboxItem is an item of the outbox table.
+type outboxItem struct {
+	id       string
+	reports  []retryReport
+	attempts int
+}
+
+func newOutbox(table string, maxAttempts int) *outbox {
+	return &outbox{
+		db:          dynamodb.New(awsSession()),
+		table:       table,
+		maxAttempts: maxAttempts,
+	}
+}
+
+// put writes the pending reports, and returns the id of the item.
+func (o *outbox) put(ctx context.Context, reps Reports) (string, error) {
+	b, err := json.Marshal(toRetryReports(reps))
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	id := strconv.FormatInt(now.UnixNano(), 10)
+	if lc, ok := lambdacontext.FromContext(ctx); ok {
+		id += "-" + lc.AwsRequestID
+	}
+	_, err = o.db.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(o.table),
+		Item: map[string]*dynamodb.AttributeValue{
+			"id":         {S: aws.String(id)},
+			"status":     {S: aws.String(outboxPending)},
+			"reports":    {S: aws.String(string(b))},
+			"attempts":   {N: aws.String("0")},
+			"created_at": {N: aws.String(strconv.FormatInt(now.Unix(), 10))},
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write the reports to the outbox: %s", err)
+	}
+	return id, nil
+}
+
+// delivered marks the item delivered, which is dropped by the TTL later.
+func (o *outbox) delivered(ctx context.Context, id string) error {
+	return o.mark(ctx, id, outboxDelivered, time.Now().Add(outboxRetention))
+}
+
+func (o *outbox) mark(ctx context.Context, id, status string, expiresAt time.Time) error {
+	_, err := o.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(o.table),
+		Key:                      map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}},
+		UpdateExpression:         aws.String("SET #status = :status, expires_at = :expires_at"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status":     {S: aws.String(status)},
+			":expires_at": {N: aws.String(strconv.FormatInt(expiresAt.Unix(), 10))},
+		},
+	})
+	return err
+}
+
+// failed keeps the reports failed to post pending, to be drained later.
+func (o *outbox) failed(ctx context.Context, id string, reps Reports, attempts int, postErr error) error {
+	b, err := json.Marshal(toRetryReports(reps))
+	if err != nil {
+		return err
+	}
+	_, err = o.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName:                aws.String(o.table),
+		Key:                      map[string]*dynamodb.AttributeValue{"id": {S: aws.String(id)}},
+		UpdateExpression:         aws.String("SET reports = :reports, attempts = attempts + :attempts, #error = :error"),
+		ExpressionAttributeNames: map[string]*string{"#error": aws.String("error")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":reports":  {S: aws.String(string(b))},
+			":attempts": {N: aws.String(strconv.Itoa(attempts))},
+			":error":    {S: aws.String(postErr.Error())},
+		},
+	})
+	return err
+}
+
+// pending returns the pending items older than outboxGrace, up to maxOutboxDrain.
+func (o *outbox) pending(ctx context.Context) ([]outboxItem, error) {
+	var (
+		items []outboxItem
+		start map[string]*dynamodb.AttributeValue
+	)
+	for {
+		out, err := o.db.ScanWithContext(ctx, &dynamodb.ScanInput{
+			TableName:                aws.String(o.table),
+			FilterExpression:         aws.String("#status = :pending AND created_at < :before"),
+			ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+			ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+				":pending": {S: aws.String(outboxPending)},
+				":before":  {N: aws.String(strconv.FormatInt(time.Now().Add(-outboxGrace).Unix(), 10))},
+			},
+			ExclusiveStartKey: start,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range out.Items {
+			item := outboxItem{id: aws.StringValue(v["id"].S)}
+			if a := v["attempts"]; a != nil {
+				item.attempts, _ = strconv.Atoi(aws.StringValue(a.N))
+			}
+			if r := v["reports"]; r != nil {
+				if err := json.Unmarshal([]byte(aws.StringValue(r.S)), &item.reports); err != nil {
+					log.Printf("skip the broken item %s of the outbox: %s", item.id, err)
+					continue
+				}
+			}
+			if items = append(items, item); len(items) >= maxOutboxDrain {
+				return items, nil
+			}
+		}
+		if start = out.LastEvaluatedKey; len(start) == 0 {
+			return items, nil
+		}
+	}
+}
+
+// drainOutbox posts the pending reports in the outbox again.
+// the ones failing OUTBOX_MAX_ATTEMPTS tries in total are saved to the dead letters.
+func (f *forwarder) drainOutbox(ctx context.Context) error {
+	if f.outbox == nil {
+		log.Println("got a drain_outbox event, but OUTBOX_TABLE is not set")
+		return nil
+	}
+	items, err := f.outbox.pending(ctx)
+	if err != nil {
+		return err
+	}
+	keys := f.conf.apiKeys()
+	var errs batchErrors
+	for _, item := range items {
+		reps := fromRetryReports(keys, item.reports)
+		postCtx, cancel := f.flushContext(ctx)
+		failed, attempts, postErr := f.post(postCtx, reps)
+		cancel()
+		if postErr == nil {
+			log.Printf("posted %d reports in the outbox %s", len(reps.Reports), item.id)
+			if err := f.outbox.delivered(ctx, item.id); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		if item.attempts+attempts < f.outbox.maxAttempts {
+			if err := f.outbox.failed(ctx, item.id, failed, attempts, postErr); err != nil {
+				errs = append(errs, err)
+			}
+			continue
+		}
+		body, _ := json.Marshal(map[string]string{"outbox": item.id})
+		if err := f.deadLetter(ctx, body, failed, item.attempts+attempts, postErr); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := f.outbox.mark(ctx, item.id, outboxDead, time.Now().Add(outboxRetention)); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs.err()
+}
@@ -0,0 +1,61 @@
+package cwa2mkr
+
+import (
+	"context"
+	"time"
+)
+
+const pagerDutyEventsEndpoint = "https://events.pagerduty.com/v2/enqueue"
+
+// pagerDutySeverities maps the mackerel status to the severity of PagerDuty events.
+var pagerDutySeverities = map[string]string{
+	StatusCritical: "critical",
+	StatusWarning:  "warning",
+	StatusUnknown:  "error",
+}
+
+// pagerDutyEvent is an event of PagerDuty Events API v2.
+// https://developer.pagerduty.com/docs/events-api-v2/trigger-events/
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string            `json:"summary"`
+	Source        string            `json:"source"`
+	Severity      string            `json:"severity"`
+	Timestamp     string            `json:"timestamp"`
+	CustomDetails map[string]string `json:"custom_details"`
+}
+
+// pagerDutyFallback sends the reports failed to post to mackerel as PagerDuty events,
+// so that an outage of mackerel does not miss pages.
+type pagerDutyFallback struct {
+	routingKey string
+}
+
+// Notify triggers an event for the report, or resolves it when OK.
+func (p *pagerDutyFallback) Notify(ctx context.Context, rep Report) error {
+	e := pagerDutyEvent{
+		RoutingKey:  p.routingKey,
+		EventAction: "trigger",
+		DedupKey:    rep.Name + "/" + rep.Source.HostID,
+	}
+	if rep.Status == StatusOK {
+		e.EventAction = "resolve"
+	} else {
+		e.Payload = &pagerDutyPayload{
+			Summary:   rep.Status + " " + rep.Name,
+			Source:    rep.Source.HostID,
+			Severity:  pagerDutySeverities[rep.Status],
+			Timestamp: time.Unix(rep.OccurredAt, 0).UTC().Format(time.RFC3339),
+			CustomDetails: map[string]string{
+				"message": rep.Message,
+			},
+		}
+	}
+	return postJSON(ctx, pagerDutyEventsEndpoint, e, nil)
+}
@@ -0,0 +1,81 @@
+package cwa2mkr
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const defaultHTTPTimeout = 10 * time.Second
+
+// httpSettings sends the requests to mackerel and the other outputs, carried by the context of the invocation
+// so that the forwarders of the different settings (reloaded or overridden) never share them.
+type httpSettings struct {
+	// the proxy is given by HTTPS_PROXY, HTTP_PROXY and NO_PROXY by default, or PROXY_URL.
+	client *http.Client
+
+	// bounds each request by HTTP_TIMEOUT_SECONDS, not to be stalled by a hung connection until the lambda times out.
+	timeout time.Duration
+}
+
+type httpKey struct{}
+
+// withHTTP returns the context to send the requests by the settings.
+func (c *config) withHTTP(ctx context.Context) context.Context {
+	return context.WithValue(ctx, httpKey{}, httpSettings{client: c.httpClient, timeout: c.httpTimeout})
+}
+
+// httpFrom returns the settings of the context, or the defaults.
+func httpFrom(ctx context.Context) httpSettings {
+	s, ok := ctx.Value(httpKey{}).(httpSettings)
+	if !ok || s.client == nil {
+		return httpSettings{client: http.DefaultClient, timeout: defaultHTTPTimeout}
+	}
+	return s
+}
+
+// newRequest returns the request bounded by ctx and the timeout. cancel should be called after the response body is read.
+func newRequest(ctx context.Context, method, url string, body io.Reader) (*http.Request, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(ctx, httpFrom(ctx).timeout)
+	req, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return req, cancel, nil
+}
+
+// sendRequest sends the request by the client of its context.
+func sendRequest(req *http.Request) (*http.Response, error) {
+	return httpFrom(req.Context()).client.Do(req)
+}
+
+// newProxyClient returns the client via the proxy, except for the hosts in noProxy (comma separated, like NO_PROXY).
+func newProxyClient(proxy *url.URL, noProxy string) *http.Client {
+	var excludes []string
+	for _, s := range strings.Split(noProxy, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			excludes = append(excludes, strings.ToLower(s))
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = func(req *http.Request) (*url.URL, error) {
+		host := strings.ToLower(req.URL.Hostname())
+		for _, e := range excludes {
+			if e == "*" || host == strings.TrimPrefix(e, ".") || strings.HasSuffix(host, "."+strings.TrimPrefix(e, ".")) {
+				return nil, nil
+			}
+			if _, n, err := net.ParseCIDR(e); err == nil {
+				if ip := net.ParseIP(host); ip != nil && n.Contains(ip) {
+					return nil, nil
+				}
+			}
+		}
+		return proxy, nil
+	}
+	return &http.Client{Transport: transport}
+}
@@ -0,0 +1,104 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/ssm"
+	"github.com/aws/aws-sdk-go/service/sts"
+)
+
+// pseudoHostID returns the id of the pseudo host for this lambda, which is used when HOST_ID is not set.
+// the host is registered to mackerel at the first time, and its id is saved in the SSM parameter.
+func pseudoHostID(ctx context.Context, conf *config) (string, error) {
+	functionName := os.Getenv("AWS_LAMBDA_FUNCTION_NAME")
+	param := conf.hostIDParameter
+	if param == "" {
+		if functionName == "" {
+			return "", fmt.Errorf("HOST_ID or HOST_ID_PARAMETER is required out of lambda")
+		}
+		param = "/cloudwatch-alarm-to-mackerel/" + functionName + "/host-id"
+	}
+
+	svc := ssm.New(awsSession())
+	out, err := svc.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name: aws.String(param),
+	})
+	if err == nil {
+		return aws.StringValue(out.Parameter.Value), nil
+	}
+	if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != ssm.ErrCodeParameterNotFound {
+		return "", fmt.Errorf("failed to get %s: %s", param, err)
+	}
+
+	identity, err := sts.New(awsSession()).GetCallerIdentityWithContext(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", err
+	}
+	name := aws.StringValue(identity.Account)
+	if functionName != "" {
+		name = functionName + "." + name
+	}
+
+	client := conf.newClient(conf.apiKey)
+	hostID, err := client.createHost(ctx, createHostParam{
+		Name:             name,
+		CustomIdentifier: name,
+	})
+	if err != nil {
+		return "", err
+	}
+	log.Printf("registered the pseudo host %s (%s)", name, hostID)
+
+	// not overwritten, so the first one wins when the instances start concurrently
+	_, err = svc.PutParameterWithContext(ctx, &ssm.PutParameterInput{
+		Name:  aws.String(param),
+		Value: aws.String(hostID),
+		Type:  aws.String(ssm.ParameterTypeString),
+	})
+	if aerr, ok := err.(awserr.Error); ok && aerr.Code() == ssm.ErrCodeParameterAlreadyExists {
+		log.Printf("the pseudo host is registered by another instance, so retire %s", hostID)
+		if err := client.retireHost(ctx, hostID); err != nil {
+			log.Printf("failed to retire the pseudo host %s: %s", hostID, err)
+		}
+		out, err := svc.GetParameterWithContext(ctx, &ssm.GetParameterInput{
+			Name: aws.String(param),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to get %s: %s", param, err)
+		}
+		return aws.StringValue(out.Parameter.Value), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to save the host id %s to %s: %s", hostID, param, err)
+	}
+
+	return hostID, nil
+}
+
+// pseudoHostID returns the id of the pseudo host resolved once for the forwarders, even reloaded.
+func (l *loader) pseudoHostID(ctx context.Context, conf *config) (string, error) {
+	key := conf.hostIDParameter + ":" + keyID(conf.apiKey)
+	l.mu.Lock()
+	hostID, ok := l.pseudoHosts[key]
+	l.mu.Unlock()
+	if ok {
+		return hostID, nil
+	}
+
+	hostID, err := pseudoHostID(ctx, conf)
+	if err != nil {
+		return "", err
+	}
+	l.mu.Lock()
+	if l.pseudoHosts == nil {
+		l.pseudoHosts = make(map[string]string)
+	}
+	l.pseudoHosts[key] = hostID
+	l.mu.Unlock()
+	return hostID, nil
+}
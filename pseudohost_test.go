@@ -0,0 +1,30 @@
+package cwa2mkr
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLoaderPseudoHostID(t *testing.T) {
+	aws := newFakeServer(t, func(call fakeCall) (int, interface{}) {
+		if call.op == "AmazonSSM.GetParameter" {
+			return 200, map[string]interface{}{"Parameter": map[string]string{"Name": "/host-id", "Value": "pseudo"}}
+		}
+		return 0, nil
+	})
+	useFakeAWS(t, aws)
+	l := &loader{}
+	conf := &config{hostIDParameter: "/host-id", apiKey: "apikey"}
+	for i := 0; i < 3; i++ {
+		hostID, err := l.pseudoHostID(context.Background(), conf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hostID != "pseudo" {
+			t.Errorf("the pseudo host %q, want pseudo", hostID)
+		}
+	}
+	if n := len(aws.called("AmazonSSM.GetParameter", "")); n != 1 {
+		t.Errorf("got the parameter %d times, want once", n)
+	}
+}
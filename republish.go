@@ -0,0 +1,61 @@
+package cwa2mkr
+
+import (
+	"context"
+	"log"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sns"
+)
+
+// republished is an alarm posted to mackerel, and its report.
+type republished struct {
+	// the original message of the alarm
+	message string
+	report  Report
+}
+
+// republisher publishes the alarms posted to mackerel to the downstream SNS topic,
+// with the computed mackerel status as the message attributes.
+type republisher struct {
+	sns   *sns.SNS
+	topic string
+}
+
+func newRepublisher(topic string) *republisher {
+	return &republisher{
+		sns:   sns.New(awsSession()),
+		topic: topic,
+	}
+}
+
+func (r *republisher) publish(ctx context.Context, p republished) error {
+	attrs := map[string]*sns.MessageAttributeValue{
+		"mackerel_status": {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(p.report.Status),
+		},
+		"mackerel_check": {
+			DataType:    aws.String("String"),
+			StringValue: aws.String(p.report.Name),
+		},
+	}
+	// empty string is not allowed as the value
+	if hostID := p.report.Source.HostID; hostID != "" {
+		attrs["mackerel_host_id"] = &sns.MessageAttributeValue{
+			DataType:    aws.String("String"),
+			StringValue: aws.String(hostID),
+		}
+	}
+
+	out, err := r.sns.PublishWithContext(ctx, &sns.PublishInput{
+		TopicArn:          aws.String(r.topic),
+		Message:           aws.String(p.message),
+		MessageAttributes: attrs,
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("republished %s to %s: %s", p.report.Name, r.topic, aws.StringValue(out.MessageId))
+	return nil
+}
@@ -0,0 +1,101 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/resourcegroupstaggingapi"
+)
+
+// resourceARNFormats are the formats of the ARN of alarmed resources, with region, account id and the dimension value.
+// keyed by namespace, and tried in order of dimensions.
+var resourceARNFormats = map[string][]struct{ dimension, format string }{
+	"AWS/EC2":            {{"InstanceId", "arn:aws:ec2:%s:%s:instance/%s"}},
+	"AWS/RDS":            {{"DBInstanceIdentifier", "arn:aws:rds:%s:%s:db:%s"}, {"DBClusterIdentifier", "arn:aws:rds:%s:%s:cluster:%s"}},
+	"AWS/Lambda":         {{"FunctionName", "arn:aws:lambda:%s:%s:function:%s"}},
+	"AWS/ApplicationELB": {{"LoadBalancer", "arn:aws:elasticloadbalancing:%s:%s:loadbalancer/%s"}},
+	"AWS/SQS":            {{"QueueName", "arn:aws:sqs:%s:%s:%s"}},
+	"AWS/DynamoDB":       {{"TableName", "arn:aws:dynamodb:%s:%s:table/%s"}},
+	"AWS/ECS":            {{"ClusterName", "arn:aws:ecs:%s:%s:cluster/%s"}},
+}
+
+// resourceARN returns the ARN of the alarmed resource, or empty if unknown.
+func resourceARN(msg Alarm) string {
+	region, account := alarmRegionAccount(msg.AlarmArn)
+	if region == "" {
+		return ""
+	}
+	for _, f := range resourceARNFormats[msg.Trigger.Namespace] {
+		if v := msg.Trigger.Dimension(f.dimension); v != "" {
+			return fmt.Sprintf(f.format, region, account, v)
+		}
+	}
+	return ""
+}
+
+// newResourceTags returns the cache of the tags of AWS resources, by Resource Groups Tagging API.
+func newResourceTags() *tagCache {
+	var mu sync.Mutex
+	clients := make(map[string]*resourcegroupstaggingapi.ResourceGroupsTaggingAPI)
+
+	return &tagCache{
+		fetch: func(ctx context.Context, arn string) (map[string]string, error) {
+			// the API is regional
+			region, _ := alarmRegionAccount(arn)
+			mu.Lock()
+			svc, ok := clients[region]
+			if !ok {
+				svc = resourcegroupstaggingapi.New(awsSession(), aws.NewConfig().WithRegion(region))
+				clients[region] = svc
+			}
+			mu.Unlock()
+
+			out, err := svc.GetResourcesWithContext(ctx, &resourcegroupstaggingapi.GetResourcesInput{
+				ResourceARNList: []*string{aws.String(arn)},
+			})
+			if err != nil {
+				return nil, err
+			}
+			tags := make(map[string]string)
+			for _, m := range out.ResourceTagMappingList {
+				for _, tag := range m.Tags {
+					tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+				}
+			}
+			return tags, nil
+		},
+		cache: make(map[string]cachedTags),
+	}
+}
+
+// enrich sets the mackerel service and role of the alarm from the tags of the alarmed resource,
+// and the runbook from the tag of the alarm.
+func (c *config) enrich(ctx context.Context, msg *Alarm) error {
+	if c.runbookTags != nil && msg.AlarmArn != "" {
+		tags, err := c.runbookTags.get(ctx, msg.AlarmArn)
+		if err != nil {
+			// the runbook should not block the alert
+			log.Printf("failed to get the runbook of %s: %s", msg.AlarmName, err)
+		} else {
+			msg.Runbook = tags[c.runbookTag]
+		}
+	}
+
+	if c.resourceTags == nil {
+		return nil
+	}
+	arn := resourceARN(*msg)
+	if arn == "" {
+		return nil
+	}
+	tags, err := c.resourceTags.get(ctx, arn)
+	if err != nil {
+		return fmt.Errorf("failed to get tags of %s: %s", arn, err)
+	}
+	msg.Service = tags[c.serviceTag]
+	msg.Role = tags[c.roleTag]
+	return nil
+}
@@ -0,0 +1,29 @@
+package cwa2mkr
+
+import (
+	"context"
+)
+
+// actions for the reports to retired (or not existing) hosts
+const (
+	retiredSkip     = "skip"
+	retiredFallback = "fallback"
+)
+
+// hostAlive reports whether the host exists and is not retired, with caching.
+func (f *forwarder) hostAlive(ctx context.Context, src Source) (bool, error) {
+	id, err := f.conf.hostCache.lookup(ctx, "alive:"+src.HostID, func(ctx context.Context) (string, error) {
+		host, err := f.clientFor(src).getHost(ctx, src.HostID)
+		if isNotFound(err) {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+		if host.IsRetired {
+			return "", nil
+		}
+		return host.ID, nil
+	})
+	return id != "", err
+}
@@ -0,0 +1,261 @@
+package cwa2mkr
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/sqs"
+)
+
+const (
+	defaultRetryDelay       = 60
+	defaultRetryMaxAttempts = 5
+)
+
+// retryMessage is a message of RETRY_QUEUE_URL, the reports failed to post.
+type retryMessage struct {
+	Error    string        `json:"error"`
+	Attempts int           `json:"attempts"` // the tries to post, by POST_MAX_ATTEMPTS for each round
+	Rounds   int           `json:"rounds"`   // the rounds to post, the first one and the retries by the queue
+	Reports  []retryReport `json:"reports"`
+
+	// the reports posted, to verify the delivery (VERIFY_DELIVERY), not to post again
+	Verify bool `json:"verify,omitempty"`
+}
+
+// retryReport is a report with the id of its API key, not to put the key itself in the queue.
+type retryReport struct {
+	Report
+	Org string `json:"org,omitempty"`
+}
+
+// toRetryReports identifies the API keys of the reports, not to store the keys.
+func toRetryReports(reps Reports) []retryReport {
+	out := make([]retryReport, 0, len(reps.Reports))
+	for _, rep := range reps.Reports {
+		r := retryReport{Report: rep}
+		if rep.Source.apiKey != "" {
+			r.Org = keyID(rep.Source.apiKey)
+		}
+		out = append(out, r)
+	}
+	return out
+}
+
+// fromRetryReports restores the API keys of the reports by the keys configured.
+// the reports of the organizations not configured are skipped.
+func fromRetryReports(keys map[string]string, reports []retryReport) Reports {
+	var reps Reports
+	for _, r := range reports {
+		rep := r.Report
+		if r.Org != "" {
+			if rep.Source.apiKey = keys[r.Org]; rep.Source.apiKey == "" {
+				log.Printf("skip %s, the API key of the organization is not configured", rep.Name)
+				continue
+			}
+		}
+		reps.Reports = append(reps.Reports, rep)
+	}
+	return reps
+}
+
+// keyID identifies the API key.
+func keyID(apiKey string) string {
+	sum := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(sum[:8])
+}
+
+// apiKeys returns the API keys configured, to find the key of a retried report.
+func (c *config) apiKeys() map[string]string {
+	keys := map[string]string{keyID(c.apiKey): c.apiKey}
+	add := func(key string) {
+		if key != "" {
+			keys[keyID(key)] = key
+		}
+	}
+	for _, o := range c.mirrorOrgs {
+		add(o.APIKey)
+	}
+	for _, r := range c.resolvers {
+		switch r := r.(type) {
+		case *hostRoutes:
+			for _, route := range r.routes {
+				add(route.APIKey)
+			}
+		case *destinationRules:
+			for _, rule := range r.rules {
+				for _, d := range rule.Destinations {
+					add(d.APIKey)
+				}
+			}
+		}
+	}
+	return keys
+}
+
+// retryQueue defers posting the failed reports by the SQS queue drained by this lambda.
+type retryQueue struct {
+	sqs         *sqs.SQS
+	queueURL    string
+	delay       int64 // seconds
+	maxAttempts int
+}
+
+func newRetryQueue(queueURL string, delay int64, maxAttempts int) *retryQueue {
+	return &retryQueue{
+		sqs:         sqs.New(awsSession()),
+		queueURL:    queueURL,
+		delay:       delay,
+		maxAttempts: maxAttempts,
+	}
+}
+
+func (q *retryQueue) enqueue(ctx context.Context, reps Reports, attempts, rounds int, postErr error) error {
+	msg := retryMessage{
+		Error:    postErr.Error(),
+		Attempts: attempts,
+		Rounds:   rounds,
+		Reports:  toRetryReports(reps),
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	out, err := q.sqs.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:     aws.String(q.queueURL),
+		MessageBody:  aws.String(string(b)),
+		DelaySeconds: aws.Int64(q.delay),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			"attempts": {
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(attempts)),
+			},
+			"rounds": {
+				DataType:    aws.String("Number"),
+				StringValue: aws.String(strconv.Itoa(rounds)),
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("enqueued %d reports to retry in %d seconds: %s", len(reps.Reports), q.delay, aws.StringValue(out.MessageId))
+	return nil
+}
+
+// enqueueVerification enqueues the reports posted, to verify the delivery after the delay.
+func (q *retryQueue) enqueueVerification(ctx context.Context, reps Reports, delay time.Duration) error {
+	b, err := json.Marshal(retryMessage{Reports: toRetryReports(reps), Verify: true})
+	if err != nil {
+		return err
+	}
+	_, err = q.sqs.SendMessageWithContext(ctx, &sqs.SendMessageInput{
+		QueueUrl:     aws.String(q.queueURL),
+		MessageBody:  aws.String(string(b)),
+		DelaySeconds: aws.Int64(int64(delay / time.Second)),
+	})
+	return err
+}
+
+// sqsRecord is a record of the event from SQS.
+type sqsRecord struct {
+	EventSource string `json:"eventSource"`
+	MessageID   string `json:"messageId"`
+	Body        string `json:"body"`
+}
+
+// sqsBatchResponse is the partial batch response to SQS, for ReportBatchItemFailures of the event source mapping.
+type sqsBatchResponse struct {
+	BatchItemFailures []sqsItemFailure `json:"batchItemFailures"`
+}
+
+type sqsItemFailure struct {
+	ItemIdentifier string `json:"itemIdentifier"`
+}
+
+// drain posts the reports in the messages of RETRY_QUEUE_URL.
+// failed reports are enqueued again until RETRY_MAX_ATTEMPTS, and saved to the dead letters at last.
+// the messages failed even so are returned as batchItemFailures by RETRY_QUEUE_BATCH_ITEM_FAILURES,
+// not to redrive the others posted already. or the first error fails the whole batch.
+func (f *forwarder) drain(ctx context.Context, records []sqsRecord) (*sqsBatchResponse, error) {
+	if f.retryQueue == nil {
+		return nil, errors.New("got messages from SQS, but RETRY_QUEUE_URL is not set")
+	}
+	keys := f.conf.apiKeys()
+	var resp *sqsBatchResponse
+	if f.conf.retryBatchItemFailures {
+		resp = &sqsBatchResponse{BatchItemFailures: []sqsItemFailure{}}
+	}
+	for _, record := range records {
+		if err := f.drainRecord(ctx, keys, record); err != nil {
+			if resp == nil {
+				return nil, err
+			}
+			log.Printf("failed to retry the message %s: %s", record.MessageID, err)
+			resp.BatchItemFailures = append(resp.BatchItemFailures, sqsItemFailure{ItemIdentifier: record.MessageID})
+		}
+	}
+	return resp, nil
+}
+
+func (f *forwarder) drainRecord(ctx context.Context, keys map[string]string, record sqsRecord) error {
+	var msg retryMessage
+	if err := json.Unmarshal([]byte(record.Body), &msg); err != nil {
+		log.Printf("skip the message %s: %s", record.MessageID, err)
+		return nil
+	}
+	if msg.Verify {
+		f.verifyDelivery(ctx, fromRetryReports(keys, msg.Reports))
+		return nil
+	}
+
+	// SQS may deliver a message more than once too
+	if f.conf.deliveryMode == exactlyOnce {
+		key := "retry:" + record.MessageID
+		ok, err := f.idempotency.claimKey(ctx, key, "retry")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			log.Printf("skip the message retried already: %s", record.MessageID)
+			return nil
+		}
+		if err := f.retryReports(ctx, keys, record, msg); err != nil {
+			if err := f.idempotency.releaseKey(ctx, key); err != nil {
+				log.Printf("failed to release the idempotency key of the message %s: %s", record.MessageID, err)
+			}
+			return err
+		}
+		return nil
+	}
+	return f.retryReports(ctx, keys, record, msg)
+}
+
+// retryReports posts the reports of the message, enqueues them again if failed, or saves them to the dead letters at last.
+func (f *forwarder) retryReports(ctx context.Context, keys map[string]string, record sqsRecord, msg retryMessage) error {
+	reps := fromRetryReports(keys, msg.Reports)
+
+	postCtx, cancel := f.flushContext(ctx)
+	failed, attempts, err := f.post(postCtx, reps)
+	cancel()
+	if err == nil {
+		log.Printf("posted %d reports retried", len(reps.Reports))
+		return nil
+	}
+	reps = failed
+	attempts += msg.Attempts
+	// RETRY_MAX_ATTEMPTS counts the rounds, not the tries to post in each round
+	rounds := msg.Rounds + 1
+	if rounds < f.retryQueue.maxAttempts {
+		return f.retryQueue.enqueue(ctx, reps, attempts, rounds, err)
+	}
+	body, _ := json.Marshal(record)
+	return f.deadLetter(ctx, body, reps, attempts, err)
+}
@@ -0,0 +1,125 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// rewriteRule rewrites a check name. exactly one of Pattern, StripPrefix and Template should be set.
+//
+//	[
+//	  {"pattern": "^tf-[0-9]+-(.+)-[a-z0-9]+$", "replace": "$1"},
+//	  {"strip_prefix": "prod-"},
+//	  {"template": "{{ .Trigger.Namespace }} {{ .Name }}"}
+//	]
+type rewriteRule struct {
+	// regexp find/replace, Replace can refer the capture groups like "$1"
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+
+	StripPrefix string `json:"strip_prefix"`
+
+	// text/template executed with rewriteData
+	Template string `json:"template"`
+
+	re   *regexp.Regexp
+	tmpl *template.Template
+}
+
+// rewriteData is passed to a template of rewrite rules.
+type rewriteData struct {
+	Alarm
+
+	// the name rewritten by the preceding rules
+	Name string
+}
+
+func parseRewriteRules(s string) ([]*rewriteRule, error) {
+	var rules []*rewriteRule
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, err
+	}
+	for i, r := range rules {
+		var err error
+		n := 0
+		for _, v := range []string{r.Pattern, r.StripPrefix, r.Template} {
+			if v != "" {
+				n++
+			}
+		}
+		switch {
+		case n > 1:
+			err = errors.New("only one of pattern, strip_prefix or template can be set")
+		case r.Pattern != "":
+			r.re, err = regexp.Compile(r.Pattern)
+		case r.Template != "":
+			r.tmpl, err = template.New(fmt.Sprintf("rule%d", i)).Parse(r.Template)
+		case r.StripPrefix != "":
+		default:
+			err = errors.New("one of pattern, strip_prefix or template is required")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("rule[%d]: %s", i, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *rewriteRule) apply(name string, msg Alarm) (string, error) {
+	switch {
+	case r.re != nil:
+		return r.re.ReplaceAllString(name, r.Replace), nil
+	case r.tmpl != nil:
+		var b bytes.Buffer
+		if err := r.tmpl.Execute(&b, rewriteData{Alarm: msg, Name: name}); err != nil {
+			return name, err
+		}
+		return b.String(), nil
+	default:
+		return strings.TrimPrefix(name, r.StripPrefix), nil
+	}
+}
+
+// checkName renders the name of the check by CHECK_NAME_TEMPLATE, or AlarmName by default.
+// NAME_REWRITE_RULES are applied to it later.
+func (c *config) checkName(msg Alarm) (string, error) {
+	if c.nameTemplate == nil {
+		return msg.AlarmName, nil
+	}
+	region, _ := alarmRegionAccount(msg.AlarmArn)
+	var b bytes.Buffer
+	if err := c.nameTemplate.Execute(&b, messageData{Alarm: msg, RegionCode: region}); err != nil {
+		return "", err
+	}
+	if b.Len() == 0 {
+		return msg.AlarmName, nil
+	}
+	return b.String(), nil
+}
+
+// prefixRule prefixes the name by ENV_PREFIX (e.g. "[prod] "), which can be a template like rewrite rules.
+// it is applied after NAME_REWRITE_RULES.
+func prefixRule(prefix string) (*rewriteRule, error) {
+	text := prefix + "{{ .Name }}"
+	tmpl, err := template.New("prefix").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &rewriteRule{Template: text, tmpl: tmpl}, nil
+}
+
+// rewriteName applies all rules in order.
+func rewriteName(rules []*rewriteRule, name string, msg Alarm) (string, error) {
+	for _, r := range rules {
+		var err error
+		if name, err = r.apply(name, msg); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
+}
@@ -0,0 +1,28 @@
+package cwa2mkr
+
+import "testing"
+
+func TestParseRewriteRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		ok    bool
+	}{
+		{"pattern", `[{"pattern": "^tf-(.+)$", "replace": "$1"}]`, true},
+		{"strip_prefix", `[{"strip_prefix": "prod-"}]`, true},
+		{"template", `[{"template": "{{ .Name }}"}]`, true},
+		{"none", `[{"replace": "$1"}]`, false},
+		{"pattern and template", `[{"pattern": "^tf-(.+)$", "replace": "$1", "template": "{{ .Name }}"}]`, false},
+		{"pattern and strip_prefix", `[{"pattern": "^tf-(.+)$", "strip_prefix": "prod-"}]`, false},
+		{"strip_prefix and template", `[{"strip_prefix": "prod-", "template": "{{ .Name }}"}]`, false},
+		{"invalid pattern", `[{"pattern": "("}]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseRewriteRules(tt.rules)
+			if ok := err == nil; ok != tt.ok {
+				t.Errorf("parseRewriteRules(%s): %v", tt.rules, err)
+			}
+		})
+	}
+}
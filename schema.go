@@ -0,0 +1,227 @@
+package cwa2mkr
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configSchema is the JSON Schema of CONFIG_FILE, published as config.schema.json.
+//
+//go:embed config.schema.json
+var configSchema []byte
+
+// jsonSchema is the subset of JSON Schema used by config.schema.json.
+type jsonSchema struct {
+	Type                 schemaTypes            `json:"type"`
+	Description          string                 `json:"description"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Required             []string               `json:"required"`
+	Items                *jsonSchema            `json:"items"`
+	Enum                 []interface{}          `json:"enum"`
+	Minimum              *float64               `json:"minimum"`
+}
+
+// schemaTypes is "type" of a string or a list of strings.
+type schemaTypes []string
+
+func (t *schemaTypes) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*t = schemaTypes{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(t))
+}
+
+// schemaError is a violation of the schema at the path like "HOST_ROUTES[1].host_id".
+type schemaError struct {
+	path    string
+	message string
+}
+
+// validateConfigFile validates the settings decoded from the file against config.schema.json.
+// the errors have the line of the setting in the file.
+func validateConfigFile(raw map[string]interface{}, file []byte) error {
+	var schema jsonSchema
+	if err := json.Unmarshal(configSchema, &schema); err != nil {
+		return fmt.Errorf("config.schema.json is broken: %s", err)
+	}
+	settings := make(map[string]interface{}, len(raw))
+	for name, v := range raw {
+		settings[strings.ToUpper(name)] = jsonCompatible(v)
+	}
+
+	var errs []schemaError
+	schema.validate("", settings, &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	lines := make([]string, 0, len(errs))
+	for _, e := range errs {
+		name := strings.SplitN(strings.SplitN(e.path, "[", 2)[0], ".", 2)[0]
+		if line := settingLine(file, name); line > 0 {
+			lines = append(lines, fmt.Sprintf("line %d: %s: %s", line, e.path, e.message))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: %s", e.path, e.message))
+		}
+	}
+	return fmt.Errorf("does not match config.schema.json:\n%s", strings.Join(lines, "\n"))
+}
+
+// settingLine returns the line number of the key of the setting in the file (or .env), or 0 if not found.
+func settingLine(file []byte, name string) int {
+	re := regexp.MustCompile(`^\s*(export\s+)?["']?(?i:` + regexp.QuoteMeta(name) + `)["']?\s*[:=]`)
+	for i, line := range strings.Split(string(file), "\n") {
+		if re.MatchString(line) {
+			return i + 1
+		}
+	}
+	return 0
+}
+
+func (s *jsonSchema) validate(path string, v interface{}, errs *[]schemaError) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, schemaError{path: path, message: fmt.Sprintf(format, args...)})
+	}
+
+	if len(s.Type) > 0 && !s.Type.accepts(v) {
+		fail("must be %s, but got %s", strings.Join(s.Type, " or "), jsonType(v))
+		return
+	}
+	if len(s.Enum) > 0 {
+		var ok bool
+		for _, e := range s.Enum {
+			if fmt.Sprint(e) == fmt.Sprint(v) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			values := make([]string, 0, len(s.Enum))
+			for _, e := range s.Enum {
+				values = append(values, fmt.Sprintf("%q", fmt.Sprint(e)))
+			}
+			fail("must be one of %s, but got %q", strings.Join(values, ", "), fmt.Sprint(v))
+		}
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.Required {
+			if _, ok := v[name]; !ok {
+				*errs = append(*errs, schemaError{path: joinPath(path, name), message: "is required"})
+			}
+		}
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if p, ok := s.Properties[name]; ok {
+				p.validate(joinPath(path, name), v[name], errs)
+				continue
+			}
+			switch additional := strings.TrimSpace(string(s.AdditionalProperties)); additional {
+			case "", "true":
+			case "false":
+				*errs = append(*errs, schemaError{path: joinPath(path, name), message: "is unknown"})
+			default:
+				var p jsonSchema
+				if err := json.Unmarshal(s.AdditionalProperties, &p); err == nil {
+					p.validate(joinPath(path, name), v[name], errs)
+				}
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, e := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), e, errs)
+			}
+		}
+	case string:
+		// the structured settings can be JSON strings, which are validated as the decoded values
+		if s.Type.accepts([]interface{}{}) && strings.HasPrefix(strings.TrimSpace(v), "[") {
+			var decoded []interface{}
+			if err := json.Unmarshal([]byte(v), &decoded); err != nil {
+				fail("is not JSON: %s", err)
+				return
+			}
+			s.validate(path, decoded, errs)
+			return
+		}
+		// the numbers can be strings like the environment variables
+		if n, err := strconv.ParseFloat(v, 64); err == nil && s.Minimum != nil && n < *s.Minimum {
+			fail("must be %v or more, but got %v", *s.Minimum, v)
+		}
+	default:
+		if n, ok := number(v); ok && s.Minimum != nil && n < *s.Minimum {
+			fail("must be %v or more, but got %v", *s.Minimum, v)
+		}
+	}
+}
+
+func (t schemaTypes) accepts(v interface{}) bool {
+	for _, typ := range t {
+		switch actual := jsonType(v); {
+		case typ == actual:
+			return true
+		case typ == "number" && actual == "integer":
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType returns the JSON Schema type of the value decoded by yaml.v2 and jsonCompatible.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	default:
+		if n, ok := number(v); ok {
+			if n == math.Trunc(n) {
+				return "integer"
+			}
+			return "number"
+		}
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+func number(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,52 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/base64"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/ssm"
+)
+
+// getParameter returns the value of the SSM parameter, decrypted if SecureString.
+func getParameter(ctx context.Context, name string) (string, error) {
+	out, err := ssm.New(awsSession()).GetParameterWithContext(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.Parameter.Value), nil
+}
+
+// decryptEnv decrypts the base64 encoded blob encrypted by KMS.
+// the encryption context of the console helpers of lambda (LambdaFunctionName) is tried first.
+func decryptEnv(ctx context.Context, encrypted string) (string, error) {
+	blob, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return "", err
+	}
+	svc := kms.New(awsSession())
+	in := &kms.DecryptInput{CiphertextBlob: blob}
+	if fn := os.Getenv("AWS_LAMBDA_FUNCTION_NAME"); fn != "" {
+		out, err := svc.DecryptWithContext(ctx, &kms.DecryptInput{
+			CiphertextBlob:    blob,
+			EncryptionContext: map[string]*string{"LambdaFunctionName": aws.String(fn)},
+		})
+		if err == nil {
+			return string(out.Plaintext), nil
+		}
+		if aerr, ok := err.(awserr.Error); !ok || aerr.Code() != kms.ErrCodeInvalidCiphertextException {
+			return "", err
+		}
+	}
+	out, err := svc.DecryptWithContext(ctx, in)
+	if err != nil {
+		return "", err
+	}
+	return string(out.Plaintext), nil
+}
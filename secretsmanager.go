@@ -0,0 +1,193 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/secretsmanager"
+)
+
+const (
+	secretPrefix      = "secretsmanager:"
+	defaultSecretsTTL = 5 * time.Minute
+)
+
+// secretCache resolves the settings like "secretsmanager:<secret id>" (or "secretsmanager:<secret id>#<json key>")
+// to the values in AWS Secrets Manager, with caching them in memory.
+type secretCache struct {
+	ttl time.Duration
+
+	mu        sync.Mutex
+	values    map[string]string // secret id to SecretString
+	fetchedAt time.Time
+
+	// the API keys of MACKEREL_APIKEY_PARAMETER and MACKEREL_APIKEY_ENCRYPTED, fetched once at the cold start
+	apiKeys map[string]string
+}
+
+func newSecretCache(ttl time.Duration) *secretCache {
+	return &secretCache{ttl: ttl, values: make(map[string]string), apiKeys: make(map[string]string)}
+}
+
+// apiKey returns MACKEREL_APIKEY, or the one of the SSM parameter or decrypted by KMS if not set.
+// they are cached not to call SSM and KMS by every reload and every override of the settings.
+func (c *secretCache) apiKey(ctx context.Context, getenv func(string) string) (string, error) {
+	if key := getenv("MACKEREL_APIKEY"); key != "" {
+		return key, nil
+	}
+	var ref string
+	var fetch func(context.Context) (string, error)
+	if name := getenv("MACKEREL_APIKEY_PARAMETER"); name != "" {
+		ref = "ssm:" + name
+		fetch = func(ctx context.Context) (string, error) {
+			key, err := getParameter(ctx, name)
+			if err != nil {
+				return "", fmt.Errorf("failed to get MACKEREL_APIKEY_PARAMETER %s: %s", name, err)
+			}
+			return key, nil
+		}
+	} else if encrypted := getenv("MACKEREL_APIKEY_ENCRYPTED"); encrypted != "" {
+		ref = "kms:" + encrypted
+		fetch = func(ctx context.Context) (string, error) {
+			key, err := decryptEnv(ctx, encrypted)
+			if err != nil {
+				return "", fmt.Errorf("failed to decrypt MACKEREL_APIKEY_ENCRYPTED: %s", err)
+			}
+			return key, nil
+		}
+	} else {
+		return "", nil
+	}
+
+	c.mu.Lock()
+	key, ok := c.apiKeys[ref]
+	c.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	key, err := fetch(ctx)
+	if err != nil {
+		return "", err
+	}
+	c.mu.Lock()
+	c.apiKeys[ref] = key
+	c.mu.Unlock()
+	return key, nil
+}
+
+func (c *secretCache) fetch(ctx context.Context, id string) (string, error) {
+	out, err := secretsmanager.New(awsSession()).GetSecretValueWithContext(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(id),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.StringValue(out.SecretString), nil
+}
+
+// resolve returns the value of the reference.
+func (c *secretCache) resolve(ctx context.Context, ref string) (string, error) {
+	id := strings.TrimPrefix(ref, secretPrefix)
+	var jsonKey string
+	if i := strings.LastIndex(id, "#"); i >= 0 {
+		id, jsonKey = id[:i], id[i+1:]
+	}
+
+	c.mu.Lock()
+	v, ok := c.values[id]
+	c.mu.Unlock()
+	if !ok {
+		var err error
+		if v, err = c.fetch(ctx, id); err != nil {
+			return "", fmt.Errorf("failed to get the secret %s: %s", id, err)
+		}
+		c.mu.Lock()
+		if len(c.values) == 0 {
+			c.fetchedAt = time.Now()
+		}
+		c.values[id] = v
+		c.mu.Unlock()
+	}
+
+	if jsonKey == "" {
+		return v, nil
+	}
+	var m map[string]string
+	if err := json.Unmarshal([]byte(v), &m); err != nil {
+		return "", fmt.Errorf("the secret %s is not a JSON object: %s", id, err)
+	}
+	s, ok := m[jsonKey]
+	if !ok {
+		return "", fmt.Errorf("the secret %s has no key %s", id, jsonKey)
+	}
+	return s, nil
+}
+
+// resolving returns the getter of the settings resolving the references to secrets.
+// the references from TOPIC_SETTINGS or the message attributes are not resolved, not to read any secret the role can reach by them.
+// the errors are returned by err after parsing.
+func (c *secretCache) resolving(ctx context.Context, lookup func(string) (string, string, bool)) (get func(string) string, err func() error) {
+	var errs []string
+	get = func(name string) string {
+		v, layer, _ := lookup(name)
+		if !strings.HasPrefix(v, secretPrefix) {
+			return v
+		}
+		if layer == layerTopic || layer == layerAttribute {
+			errs = append(errs, fmt.Sprintf("%s: the secret is not resolved from the %s layer", name, layer))
+			return ""
+		}
+		s, e := c.resolve(ctx, v)
+		if e != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", name, e))
+		}
+		return s
+	}
+	err = func() error {
+		if len(errs) == 0 {
+			return nil
+		}
+		return fmt.Errorf("failed to resolve secrets: %s", strings.Join(errs, ", "))
+	}
+	return get, err
+}
+
+// rotated fetches the secrets again when the cache expires, and reports whether any of them is changed.
+func (c *secretCache) rotated(ctx context.Context) (bool, error) {
+	c.mu.Lock()
+	if len(c.values) == 0 || time.Since(c.fetchedAt) < c.ttl {
+		c.mu.Unlock()
+		return false, nil
+	}
+	ids := make([]string, 0, len(c.values))
+	for id := range c.values {
+		ids = append(ids, id)
+	}
+	c.mu.Unlock()
+
+	changed := false
+	fetched := make(map[string]string, len(ids))
+	for _, id := range ids {
+		v, err := c.fetch(ctx, id)
+		if err != nil {
+			return false, fmt.Errorf("failed to get the secret %s: %s", id, err)
+		}
+		fetched[id] = v
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for id, v := range fetched {
+		if c.values[id] != v {
+			changed = true
+		}
+		c.values[id] = v
+	}
+	c.fetchedAt = time.Now()
+	return changed, nil
+}
@@ -0,0 +1,91 @@
+package cwa2mkr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// alarmSelector selects the alarms by an alarm tag, a dimension, the alarm name, the namespace, the AWS account or the SNS topic.
+// exactly one of them should be set.
+type alarmSelector struct {
+	Tag       string `json:"tag"`
+	Dimension string `json:"dimension"`
+	Value     string `json:"value"`
+
+	// glob pattern of AlarmName
+	Alarm string `json:"alarm"`
+
+	Namespace string `json:"namespace"`
+
+	// AWSAccountId of the alarm
+	Account string `json:"account"`
+
+	// TopicArn delivered the alarm
+	Topic string `json:"topic"`
+
+	alarm *regexp.Regexp
+}
+
+func (s *alarmSelector) compile() error {
+	n := 0
+	for _, selector := range []string{s.Tag, s.Dimension, s.Alarm, s.Namespace, s.Account, s.Topic} {
+		if selector != "" {
+			n++
+		}
+	}
+	if n != 1 {
+		return errors.New("one of tag, dimension, alarm, namespace, account or topic is required")
+	}
+	if s.Alarm != "" {
+		var err error
+		if s.alarm, err = compileGlob(s.Alarm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// alarmTags gets the tags of the alarm once, for the selectors of tags.
+type alarmTags struct {
+	cache *tagCache
+	tags  map[string]string
+}
+
+func (t *alarmTags) get(ctx context.Context, msg Alarm) (map[string]string, error) {
+	if t.tags != nil {
+		return t.tags, nil
+	}
+	if msg.AlarmArn == "" {
+		return nil, errors.New("AlarmArn is not in the message, so could not select by tags")
+	}
+	tags, err := t.cache.get(ctx, msg.AlarmArn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags of %s: %s", msg.AlarmArn, err)
+	}
+	t.tags = tags
+	return tags, nil
+}
+
+func (s *alarmSelector) match(ctx context.Context, msg Alarm, tags *alarmTags) (bool, error) {
+	switch {
+	case s.alarm != nil:
+		return s.alarm.MatchString(msg.AlarmName), nil
+	case s.Namespace != "":
+		return msg.Trigger.Namespace == s.Namespace, nil
+	case s.Account != "":
+		return msg.AWSAccountID == s.Account, nil
+	case s.Topic != "":
+		return msg.TopicArn == s.Topic, nil
+	case s.Dimension != "":
+		return msg.Trigger.Dimension(s.Dimension) == s.Value, nil
+	default:
+		t, err := tags.get(ctx, msg)
+		if err != nil {
+			return false, err
+		}
+		v, ok := t[s.Tag]
+		return ok && v == s.Value, nil
+	}
+}
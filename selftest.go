@@ -0,0 +1,77 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// selfTestResult is the response to {"selfTest": true}, a canary of the forwarder invoked by EventBridge.
+type selfTestResult struct {
+	OK     bool            `json:"ok"`
+	Checks []selfTestCheck `json:"checks"`
+}
+
+type selfTestCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Detail  string `json:"detail,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Elapsed string `json:"elapsed"`
+}
+
+// selfTest checks the settings loaded, the API keys fetched, and calls GET /api/v0/org of each organization configured,
+// which changes nothing in mackerel. the result is returned with an error if any check fails, not to pass the canary.
+func (f *forwarder) selfTest(ctx context.Context) (*selfTestResult, error) {
+	res := &selfTestResult{OK: true}
+	check := func(name string, fn func() (string, error)) {
+		start := time.Now()
+		detail, err := fn()
+		c := selfTestCheck{Name: name, OK: err == nil, Detail: detail, Elapsed: time.Since(start).String()}
+		if err != nil {
+			c.Error = err.Error()
+			res.OK = false
+		}
+		res.Checks = append(res.Checks, c)
+	}
+
+	// the settings are validated strictly as STRICT_CONFIG, even if not set
+	check("config", func() (string, error) {
+		return "", f.conf.validate(f.conf.getenv)
+	})
+
+	keys := f.conf.apiKeys()
+	ids := make([]string, 0, len(keys))
+	for id := range keys {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	check("credentials", func() (string, error) {
+		if f.conf.apiKey == "" {
+			return "", errors.New("MACKEREL_APIKEY is empty")
+		}
+		return "", nil
+	})
+	for _, id := range ids {
+		key := keys[id]
+		// the org is identified by the hash of the key, not to show the key itself
+		check("mackerel:"+id, func() (string, error) {
+			org, err := f.clientFor(Source{apiKey: key}).getOrg(ctx)
+			if err != nil {
+				return "", err
+			}
+			return org.Name, nil
+		})
+	}
+
+	if b, err := json.Marshal(res); err == nil {
+		log.Printf("self test: %s", b)
+	}
+	if !res.OK {
+		return res, errors.New("self test failed")
+	}
+	return res, nil
+}
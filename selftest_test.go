@@ -0,0 +1,31 @@
+package cwa2mkr
+
+import (
+	"context"
+	"testing"
+)
+
+func TestSelfTestConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		hostID string
+		ok     bool
+	}{
+		{"valid", "2cSZzK3XfmG", true},
+		{"invalid host id", "host", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := testForwarder(t, newFakeServer(t, nil), map[string]string{"HOST_ID": tt.hostID})
+			res, _ := f.selfTest(context.Background())
+			for _, c := range res.Checks {
+				if c.Name == "config" && c.OK != tt.ok {
+					t.Errorf("config check %v (%s), want %v", c.OK, c.Error, tt.ok)
+				}
+			}
+			if res.OK != tt.ok {
+				t.Errorf("self test %v, want %v", res.OK, tt.ok)
+			}
+		})
+	}
+}
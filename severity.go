@@ -0,0 +1,50 @@
+package cwa2mkr
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// reasonRule overrides the status of the alarm whose NewStateReason matches the pattern.
+//
+//	[
+//	  {"pattern": "no datapoints were received", "status": "WARNING"}
+//	]
+type reasonRule struct {
+	Pattern string `json:"pattern"`
+	Status  string `json:"status"`
+
+	re *regexp.Regexp
+}
+
+func parseReasonRules(s string) ([]*reasonRule, error) {
+	var rules []*reasonRule
+	if err := json.Unmarshal([]byte(s), &rules); err != nil {
+		return nil, err
+	}
+	for i, r := range rules {
+		var err error
+		if r.re, err = regexp.Compile(r.Pattern); err != nil {
+			return nil, fmt.Errorf("rule[%d]: %s", i, err)
+		}
+		if _, ok := statusSeverity[r.Status]; !ok {
+			return nil, fmt.Errorf("rule[%d]: unknown status %q", i, r.Status)
+		}
+	}
+	return rules, nil
+}
+
+// adjustStatus returns the status of the first rule matching the reason.
+// OK is never changed, because the rules are to tell a real breach from others.
+func adjustStatus(rules []*reasonRule, status string, msg Alarm) string {
+	if status == StatusOK {
+		return status
+	}
+	for _, r := range rules {
+		if r.re.MatchString(msg.NewStateReason) {
+			return r.Status
+		}
+	}
+	return status
+}
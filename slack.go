@@ -0,0 +1,35 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"context"
+	"text/template"
+)
+
+const defaultSlackTemplate = "*{{ .Status }}* {{ .Name }}\n{{ .Message }}"
+
+// slackNotifier mirrors the reports to the Slack incoming webhook.
+type slackNotifier struct {
+	url  string
+	tmpl *template.Template
+}
+
+// newSlackNotifier parses the template executed with Report.
+func newSlackNotifier(url, text string) (*slackNotifier, error) {
+	if text == "" {
+		text = defaultSlackTemplate
+	}
+	tmpl, err := template.New("slack").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &slackNotifier{url: url, tmpl: tmpl}, nil
+}
+
+func (s *slackNotifier) Notify(ctx context.Context, rep Report) error {
+	var b bytes.Buffer
+	if err := s.tmpl.Execute(&b, rep); err != nil {
+		return err
+	}
+	return postJSON(ctx, s.url, map[string]string{"text": b.String()}, nil)
+}
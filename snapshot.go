@@ -0,0 +1,69 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const defaultSnapshotKey = "status.json"
+
+// snapshot is the current states of all checks in STATE_TABLE, for a static status page.
+type snapshot struct {
+	UpdatedAt time.Time       `json:"updated_at"`
+	Checks    []checkSnapshot `json:"checks"`
+}
+
+type checkSnapshot struct {
+	Name      string    `json:"name"`
+	HostID    string    `json:"host_id"`
+	Alarm     string    `json:"alarm"`
+	Status    string    `json:"status"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// writeSnapshot writes the states to SNAPSHOT_BUCKET, overwriting the previous one.
+func (f *forwarder) writeSnapshot(ctx context.Context, states []checkState) error {
+	snap := snapshot{
+		UpdatedAt: time.Now().UTC(),
+		Checks:    make([]checkSnapshot, 0, len(states)),
+	}
+	for _, st := range states {
+		snap.Checks = append(snap.Checks, checkSnapshot{
+			Name:      st.Name,
+			HostID:    st.HostID,
+			Alarm:     st.Alarm,
+			Status:    st.Status,
+			UpdatedAt: st.UpdatedAt.UTC(),
+		})
+	}
+	sort.Slice(snap.Checks, func(i, j int) bool {
+		if snap.Checks[i].Name != snap.Checks[j].Name {
+			return snap.Checks[i].Name < snap.Checks[j].Name
+		}
+		return snap.Checks[i].HostID < snap.Checks[j].HostID
+	})
+
+	b, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	_, err = s3.New(awsSession()).PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:       aws.String(f.conf.snapshotBucket),
+		Key:          aws.String(f.conf.snapshotKey),
+		Body:         bytes.NewReader(b),
+		ContentType:  aws.String("application/json"),
+		CacheControl: aws.String("no-cache"),
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("wrote the snapshot to s3://%s/%s", f.conf.snapshotBucket, f.conf.snapshotKey)
+	return nil
+}
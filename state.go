@@ -0,0 +1,213 @@
+package cwa2mkr
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+)
+
+// stateStore keeps the last report of each check in DynamoDB.
+//
+// table schema:
+//   - partition key: "name" (S)
+//   - sort key: "host_id" (S)
+type stateStore struct {
+	db    *dynamodb.DynamoDB
+	table string
+}
+
+// checkState is an item of the state table.
+type checkState struct {
+	Name      string
+	HostID    string
+	Alarm     string
+	Status    string
+	UpdatedAt time.Time
+
+	// the times reported, since the last digest
+	Transitions []time.Time
+}
+
+func newStateStore(table string) *stateStore {
+	return &stateStore{
+		db:    dynamodb.New(awsSession()),
+		table: table,
+	}
+}
+
+// put saves the report, and appends the time to the transitions for the digest.
+func (s *stateStore) put(ctx context.Context, alarm string, rep Report) error {
+	now := aws.String(strconv.FormatInt(time.Now().Unix(), 10))
+	_, err := s.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"name":    {S: aws.String(rep.Name)},
+			"host_id": {S: aws.String(rep.Source.HostID)},
+		},
+		UpdateExpression:         aws.String("SET alarm = :alarm, #status = :status, updated_at = :now, transitions = list_append(if_not_exists(transitions, :empty), :transition)"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":alarm":      {S: aws.String(alarm)},
+			":status":     {S: aws.String(rep.Status)},
+			":now":        {N: now},
+			":empty":      {L: []*dynamodb.AttributeValue{}},
+			":transition": {L: []*dynamodb.AttributeValue{{N: now}}},
+		},
+	})
+	return err
+}
+
+// setTransitions replaces the transitions of the check, to drop the old ones.
+func (s *stateStore) setTransitions(ctx context.Context, st checkState, transitions []time.Time) error {
+	list := make([]*dynamodb.AttributeValue, 0, len(transitions))
+	for _, t := range transitions {
+		list = append(list, &dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(t.Unix(), 10))})
+	}
+	_, err := s.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"name":    {S: aws.String(st.Name)},
+			"host_id": {S: aws.String(st.HostID)},
+		},
+		UpdateExpression: aws.String("SET transitions = :transitions"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":transitions": {L: list},
+		},
+	})
+	return err
+}
+
+// setStatus updates the status of the check without touching updated_at.
+func (s *stateStore) setStatus(ctx context.Context, st checkState, status string) error {
+	_, err := s.db.UpdateItemWithContext(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(s.table),
+		Key: map[string]*dynamodb.AttributeValue{
+			"name":    {S: aws.String(st.Name)},
+			"host_id": {S: aws.String(st.HostID)},
+		},
+		UpdateExpression:         aws.String("SET #status = :status"),
+		ExpressionAttributeNames: map[string]*string{"#status": aws.String("status")},
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":status": {S: aws.String(status)},
+		},
+	})
+	return err
+}
+
+func (s *stateStore) all(ctx context.Context) ([]checkState, error) {
+	var states []checkState
+	err := s.db.ScanPagesWithContext(ctx, &dynamodb.ScanInput{
+		TableName:      aws.String(s.table),
+		ConsistentRead: aws.Bool(true),
+	}, func(out *dynamodb.ScanOutput, last bool) bool {
+		for _, item := range out.Items {
+			st := checkState{
+				Name:   attrString(item["name"]),
+				HostID: attrString(item["host_id"]),
+				Alarm:  attrString(item["alarm"]),
+				Status: attrString(item["status"]),
+			}
+			if n, err := strconv.ParseInt(attrNumber(item["updated_at"]), 10, 64); err == nil {
+				st.UpdatedAt = time.Unix(n, 0)
+			}
+			if v := item["transitions"]; v != nil {
+				for _, t := range v.L {
+					if n, err := strconv.ParseInt(attrNumber(t), 10, 64); err == nil {
+						st.Transitions = append(st.Transitions, time.Unix(n, 0))
+					}
+				}
+			}
+			states = append(states, st)
+		}
+		return true
+	})
+	return states, err
+}
+
+func attrString(v *dynamodb.AttributeValue) string {
+	if v == nil {
+		return ""
+	}
+	return aws.StringValue(v.S)
+}
+
+func attrNumber(v *dynamodb.AttributeValue) string {
+	if v == nil {
+		return ""
+	}
+	return aws.StringValue(v.N)
+}
+
+// sweep reports UNKNOWN for the checks which have not been updated for STALE_HOURS.
+func (f *forwarder) sweep(ctx context.Context) error {
+	if f.states == nil || f.conf.staleAfter == 0 {
+		log.Println("got a scheduled event, but STATE_TABLE and STALE_HOURS are not set")
+		return nil
+	}
+
+	states, err := f.states.all(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	var reps Reports
+	var stale []checkState
+	for _, st := range states {
+		if st.Status == StatusUnknown || now.Sub(st.UpdatedAt) < f.conf.staleAfter {
+			continue
+		}
+		reps.Reports = append(reps.Reports, Report{
+			Source: Source{
+				HostID: st.HostID,
+				Type:   "host",
+			},
+			Name:       st.Name,
+			Status:     StatusUnknown,
+			Message:    fmt.Sprintf("%s has not been updated since %s", st.Alarm, st.UpdatedAt.Format(time.RFC3339)),
+			OccurredAt: now.Unix(),
+		})
+		stale = append(stale, st)
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	if _, _, err := f.post(ctx, reps); err != nil {
+		return err
+	}
+	for _, st := range stale {
+		if err := f.states.setStatus(ctx, st, StatusUnknown); err != nil {
+			return err
+		}
+	}
+	f.statesChanged(ctx)
+	return nil
+}
+
+// statesChanged publishes the states to the dashboard and the snapshot. the errors are only logged.
+func (f *forwarder) statesChanged(ctx context.Context) {
+	if f.conf.dashboardID == "" && f.conf.snapshotBucket == "" {
+		return
+	}
+	states, err := f.states.all(ctx)
+	if err != nil {
+		log.Printf("failed to get the states: %s", err)
+		return
+	}
+	if f.conf.dashboardID != "" {
+		if err := f.updateDashboard(ctx, states); err != nil {
+			log.Printf("failed to update the dashboard: %s", err)
+		}
+	}
+	if f.conf.snapshotBucket != "" {
+		if err := f.writeSnapshot(ctx, states); err != nil {
+			log.Printf("failed to write the snapshot: %s", err)
+		}
+	}
+}
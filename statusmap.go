@@ -0,0 +1,76 @@
+package cwa2mkr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// statusMapping maps the transition of the alarm state to the status of mackerel, instead of the fixed logic of toMackerelStatus.
+// the empty fields match any, and the first matched mapping is used.
+//
+//	[
+//	  {"new": "ALARM", "alarm": "*-heartbeat", "status": "UNKNOWN"},
+//	  {"old": "INSUFFICIENT_DATA", "new": "OK", "status": "OK"},
+//	  {"new": "INSUFFICIENT_DATA", "status": "WARNING"}
+//	]
+type statusMapping struct {
+	// OldStateValue and NewStateValue: "OK", "ALARM" or "INSUFFICIENT_DATA"
+	Old string `json:"old"`
+	New string `json:"new"`
+
+	// glob pattern of AlarmName
+	Alarm string `json:"alarm"`
+
+	Status string `json:"status"`
+
+	alarm *regexp.Regexp
+}
+
+var alarmStates = map[string]bool{"OK": true, "ALARM": true, "INSUFFICIENT_DATA": true}
+
+func parseStatusMappings(s string) ([]*statusMapping, error) {
+	var mappings []*statusMapping
+	if err := json.Unmarshal([]byte(s), &mappings); err != nil {
+		return nil, err
+	}
+	for i, m := range mappings {
+		var err error
+		switch {
+		case m.Old != "" && !alarmStates[m.Old]:
+			err = fmt.Errorf("unknown state %q of old", m.Old)
+		case m.New != "" && !alarmStates[m.New]:
+			err = fmt.Errorf("unknown state %q of new", m.New)
+		case m.Status == "":
+			err = errors.New("status is required")
+		default:
+			if _, ok := statusSeverity[m.Status]; !ok {
+				err = fmt.Errorf("unknown status %q", m.Status)
+			}
+		}
+		if err == nil && m.Alarm != "" {
+			m.alarm, err = compileGlob(m.Alarm)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("mapping[%d]: %s", i, err)
+		}
+	}
+	return mappings, nil
+}
+
+func (m *statusMapping) match(msg Alarm) bool {
+	return (m.Old == "" || m.Old == msg.OldStateValue) &&
+		(m.New == "" || m.New == msg.NewStateValue) &&
+		(m.alarm == nil || m.alarm.MatchString(msg.AlarmName))
+}
+
+// mackerelStatus returns the status of the first mapping matching the alarm, or by toMackerelStatus.
+func (c *config) mackerelStatus(msg Alarm) string {
+	for _, m := range c.statusMappings {
+		if m.match(msg) {
+			return m.Status
+		}
+	}
+	return msg.toMackerelStatus()
+}
@@ -0,0 +1,55 @@
+package cwa2mkr
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+)
+
+// outputStdout prints the reports instead of posting to mackerel.
+const outputStdout = "stdout"
+
+// stdoutFlag reports whether the command line has -stdout flag.
+func stdoutFlag() bool {
+	return commandFlag("stdout")
+}
+
+// configFlag reports whether the command line has -config flag, to print the effective settings for the event from stdin.
+func configFlag() bool {
+	return commandFlag("config")
+}
+
+// commandFlag reports whether the command line has the flag.
+// the flags are not parsed by flag package, not to conflict with the main package.
+func commandFlag(name string) bool {
+	for _, arg := range os.Args[1:] {
+		if arg == "-"+name || arg == "--"+name {
+			return true
+		}
+	}
+	return false
+}
+
+// inLambda reports whether the process is running in lambda.
+func inLambda() bool {
+	return os.Getenv("AWS_LAMBDA_RUNTIME_API") != "" || os.Getenv("_LAMBDA_SERVER_PORT") != ""
+}
+
+// printReports writes the reports to stdout as a line of JSON.
+func printReports(reps Reports) error {
+	return json.NewEncoder(os.Stdout).Encode(reps)
+}
+
+// handleStdin handles an event read from stdin, out of lambda.
+func (f *forwarder) handleStdin(ctx context.Context) error {
+	payload, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+	resp, err := f.handle(ctx, payload)
+	if err != nil || resp == nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(resp)
+}
@@ -0,0 +1,70 @@
+package cwa2mkr
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+)
+
+const tagsTTL = 5 * time.Minute
+
+// tagCache fetches tags of AWS resources and caches them for tagsTTL.
+type tagCache struct {
+	fetch func(ctx context.Context, arn string) (map[string]string, error)
+
+	mu    sync.Mutex
+	cache map[string]cachedTags
+}
+
+type cachedTags struct {
+	tags      map[string]string
+	expiresAt time.Time
+}
+
+// newAlarmTags returns the cache of the tags of CloudWatch alarms.
+func newAlarmTags() *tagCache {
+	cw := cloudwatch.New(awsSession())
+	return &tagCache{
+		fetch: func(ctx context.Context, alarmArn string) (map[string]string, error) {
+			out, err := cw.ListTagsForResourceWithContext(ctx, &cloudwatch.ListTagsForResourceInput{
+				ResourceARN: aws.String(alarmArn),
+			})
+			if err != nil {
+				return nil, err
+			}
+			tags := make(map[string]string, len(out.Tags))
+			for _, tag := range out.Tags {
+				tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+			}
+			return tags, nil
+		},
+		cache: make(map[string]cachedTags),
+	}
+}
+
+func (t *tagCache) get(ctx context.Context, arn string) (map[string]string, error) {
+	if arn == "" {
+		return nil, nil
+	}
+
+	t.mu.Lock()
+	c, ok := t.cache[arn]
+	t.mu.Unlock()
+	if ok && time.Now().Before(c.expiresAt) {
+		return c.tags, nil
+	}
+
+	tags, err := t.fetch(ctx, arn)
+	if err != nil {
+		return nil, err
+	}
+
+	t.mu.Lock()
+	t.cache[arn] = cachedTags{tags: tags, expiresAt: time.Now().Add(tagsTTL)}
+	t.mu.Unlock()
+
+	return tags, nil
+}
@@ -0,0 +1,71 @@
+package cwa2mkr
+
+import (
+	"context"
+)
+
+// teamsColors maps the mackerel status to the color of the adaptive card text.
+var teamsColors = map[string]string{
+	StatusOK:       "Good",
+	StatusWarning:  "Warning",
+	StatusCritical: "Attention",
+	StatusUnknown:  "Default",
+}
+
+// teamsNotifier posts the reports to the Microsoft Teams incoming webhook as adaptive cards.
+type teamsNotifier struct {
+	url string
+}
+
+func (t *teamsNotifier) Notify(ctx context.Context, rep Report) error {
+	facts := []map[string]string{
+		{"title": "Status", "value": rep.Status},
+		{"title": "Host", "value": rep.Source.HostID},
+	}
+	var actions []map[string]string
+	if rep.alarm != nil {
+		facts = append(facts, map[string]string{"title": "Reason", "value": rep.alarm.NewStateReason})
+		if u := rep.alarm.consoleURL(); u != "" {
+			actions = append(actions, map[string]string{
+				"type":  "Action.OpenUrl",
+				"title": "Open in CloudWatch",
+				"url":   u,
+			})
+		}
+	} else {
+		facts = append(facts, map[string]string{"title": "Message", "value": rep.Message})
+	}
+
+	card := map[string]interface{}{
+		"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+		"type":    "AdaptiveCard",
+		"version": "1.4",
+		"body": []interface{}{
+			map[string]interface{}{
+				"type":   "TextBlock",
+				"text":   rep.Status + " " + rep.Name,
+				"weight": "Bolder",
+				"size":   "Medium",
+				"color":  teamsColors[rep.Status],
+				"wrap":   true,
+			},
+			map[string]interface{}{
+				"type":  "FactSet",
+				"facts": facts,
+			},
+		},
+	}
+	if len(actions) > 0 {
+		card["actions"] = actions
+	}
+
+	return postJSON(ctx, t.url, map[string]interface{}{
+		"type": "message",
+		"attachments": []interface{}{
+			map[string]interface{}{
+				"contentType": "application/vnd.microsoft.card.adaptive",
+				"content":     card,
+			},
+		},
+	}, nil)
+}
@@ -0,0 +1,57 @@
+package cwa2mkr
+
+import (
+	"time"
+
+	// the runtime of Lambda may have no zoneinfo
+	_ "time/tzdata"
+)
+
+// stateChangeTimeLayout is the layout of StateChangeTime like "2018-02-16T08:42:33.109+0000".
+const stateChangeTimeLayout = "2006-01-02T15:04:05.000-0700"
+
+// stateChangedAt parses StateChangeTime.
+func (m Alarm) stateChangedAt() (time.Time, error) {
+	return time.Parse(stateChangeTimeLayout, m.StateChangeTime)
+}
+
+// sources of OccurredAt of the reports, by OCCURRED_AT
+const (
+	occurredAtNow             = "now" // default
+	occurredAtStateChangeTime = "state_change_time"
+	occurredAtPublished       = "sns_timestamp"
+)
+
+// occurredAt returns OccurredAt of the report of the alarm by OCCURRED_AT, or now if the time is unknown.
+func (c *config) occurredAt(msg Alarm) int64 {
+	switch c.occurredAtSource {
+	case occurredAtStateChangeTime:
+		if t, err := msg.stateChangedAt(); err == nil {
+			return t.Unix()
+		}
+	case occurredAtPublished:
+		if !msg.PublishedAt.IsZero() {
+			return msg.PublishedAt.Unix()
+		}
+	}
+	return time.Now().Unix()
+}
+
+// formatTime renders StateChangeTime in TIMEZONE by TIME_FORMAT. it is passed through if unparsable or not configured.
+func (c *config) formatTime(msg Alarm) string {
+	if c.timeLocation == nil && c.timeFormat == "" {
+		return msg.StateChangeTime
+	}
+	t, err := msg.stateChangedAt()
+	if err != nil {
+		return msg.StateChangeTime
+	}
+	if c.timeLocation != nil {
+		t = t.In(c.timeLocation)
+	}
+	layout := c.timeFormat
+	if layout == "" {
+		layout = stateChangeTimeLayout
+	}
+	return t.Format(layout)
+}
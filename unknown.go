@@ -0,0 +1,57 @@
+package cwa2mkr
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/apex/go-apex/sns"
+)
+
+// actions for the messages not parsed as alarms, by UNKNOWN_MESSAGE_ACTION
+const (
+	unknownSkip   = "skip"   // log and skip (default)
+	unknownFail   = "fail"   // fail the invocation, to be retried or sent to the DLQ of lambda
+	unknownReport = "report" // report UNKNOWN to HOST_ID
+)
+
+// maxUnknownMessageHead is the length of the head of the message in the report.
+const maxUnknownMessageHead = 200
+
+// unknownMessage handles the message not parsed as an alarm by UNKNOWN_MESSAGE_ACTION.
+// it returns the error to fail the invocation, or the reports naming the topic to notice the broken producer.
+func (c *config) unknownMessage(record *sns.Record, reason error) ([]Report, error) {
+	topic := record.SNS.TopicARN
+	switch c.unknownAction {
+	case unknownFail:
+		return nil, fmt.Errorf("the unknown message from %s: %s", topic, reason)
+	case unknownReport:
+	default:
+		return nil, nil
+	}
+
+	head := record.SNS.Message
+	if utf8.RuneCountInString(head) > maxUnknownMessageHead {
+		head = string([]rune(head)[:maxUnknownMessageHead]) + ellipsis
+	}
+	name := topic
+	if i := strings.LastIndex(topic, ":"); i >= 0 {
+		name = topic[i+1:]
+	}
+	reps := make([]Report, 0, len(c.hostIDs))
+	for _, hostID := range c.hostIDs {
+		reps = append(reps, Report{
+			Source:     Source{Type: "host", HostID: hostID},
+			Name:       "unknown message from " + name,
+			Status:     StatusUnknown,
+			Message:    fmt.Sprintf("the message from %s is not an alarm: %s: %s", topic, reason, head),
+			OccurredAt: time.Now().Unix(),
+		})
+	}
+	return reps, nil
+}
+
+// errEmptyAlarm is the reason of the message without AlarmName or NewStateValue.
+var errEmptyAlarm = errors.New("AlarmName or NewStateValue is empty")
@@ -0,0 +1,181 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+)
+
+// hostIDPattern matches the ids of mackerel hosts like "2eQGEaLxibb".
+var hostIDPattern = regexp.MustCompile(`^[0-9A-Za-z]{11}$`)
+
+// dependentSettings have no effect without the key.
+var dependentSettings = []struct {
+	key        string
+	dependents []string
+}{
+	{"SLACK_WEBHOOK_URL", []string{"SLACK_MODE", "SLACK_TEMPLATE"}},
+	{"RETRY_QUEUE_URL", []string{"RETRY_DELAY_SECONDS", "RETRY_MAX_ATTEMPTS"}},
+	{"RESOURCE_TAGS", []string{"SERVICE_TAG", "ROLE_TAG"}},
+	{"HOST_NAME_TEMPLATE", []string{"CREATE_MISSING_HOSTS", "CREATE_HOST_ROLES"}},
+	{"DASHBOARD_ID", []string{"DASHBOARD_WIDGET_TITLE"}},
+	{"SNAPSHOT_BUCKET", []string{"SNAPSHOT_KEY"}},
+	{"DEAD_LETTER_BUCKET", []string{"DEAD_LETTER_PREFIX"}},
+	{"AUDIT_BUCKET", []string{"AUDIT_PREFIX"}},
+	{"FALLBACK_SERVICE", []string{"FALLBACK_ROLES", "FALLBACK_MODE"}},
+	{"ANNOTATION_SERVICE", []string{"ANNOTATION_ROLES"}},
+	{"DATADOG_API_KEY", []string{"DATADOG_SITE"}},
+	{"OPSGENIE_API_KEY", []string{"OPSGENIE_API_URL"}},
+	{"PROXY_URL", []string{"NO_PROXY"}},
+}
+
+// sampleAlarm is used to execute the templates at cold start.
+var sampleAlarm = Alarm{
+	AlarmName:        "sample",
+	AlarmDescription: "sample alarm to validate the configuration",
+	AlarmArn:         "arn:aws:cloudwatch:ap-northeast-1:123456789012:alarm:sample",
+	AWSAccountID:     "123456789012",
+	NewStateValue:    "ALARM",
+	OldStateValue:    "OK",
+	NewStateReason:   "Threshold Crossed: 1 datapoint [1.0 (16/02/18 08:41:00)] was greater than or equal to the threshold (0.0).",
+	StateChangeTime:  "2018-02-16T08:42:33.109+0000",
+	Region:           "Asia Pacific (Tokyo)",
+	Trigger: Trigger{
+		MetricName:         "FailedInvocations",
+		Namespace:          "AWS/Events",
+		StatisticType:      "Statistic",
+		Statistic:          "SUM",
+		Dimensions:         []Dimension{{Name: "RuleName", Value: "sample"}},
+		Period:             60,
+		EvaluationPeriods:  1,
+		ComparisonOperator: "GreaterThanOrEqualToThreshold",
+		TreatMissingData:   "- TreatMissingData: NonBreaching",
+	},
+}
+
+// validate checks the configuration strictly by STRICT_CONFIG, to fail at cold start rather than on the first alarm:
+// the host ids look like host ids, the URLs are absolute, the templates can be executed,
+// and no setting is ignored for lack of the one it depends on.
+func (c *config) validate(getenv func(string) string) error {
+	for _, id := range c.hostIDs {
+		if err := validateHostID("HOST_ID", id); err != nil {
+			return err
+		}
+	}
+	if c.fallbackHostID != "" {
+		if err := validateHostID("FALLBACK_HOST_ID", c.fallbackHostID); err != nil {
+			return err
+		}
+	}
+	for i, org := range c.mirrorOrgs {
+		if err := validateHostID(fmt.Sprintf("MIRROR_ORGS[%d]", i), org.HostID); err != nil {
+			return err
+		}
+	}
+	for _, r := range c.resolvers {
+		switch r := r.(type) {
+		case *hostRoutes:
+			for i, route := range r.routes {
+				if err := validateHostID(fmt.Sprintf("HOST_ROUTES[%d]", i), route.HostID); err != nil {
+					return err
+				}
+			}
+		case *destinationRules:
+			for i, rule := range r.rules {
+				for j, d := range rule.Destinations {
+					name := fmt.Sprintf("DESTINATION_RULES[%d].destinations[%d]", i, j)
+					if d.HostID != "" {
+						if err := validateHostID(name, d.HostID); err != nil {
+							return err
+						}
+					} else if err := validateURL(name, d.Slack); err != nil {
+						return err
+					}
+				}
+			}
+		case hostSources:
+			if h, ok := r.hostResolver.(*hostNameResolver); ok {
+				if err := h.tmpl.Execute(ioutil.Discard, sampleAlarm); err != nil {
+					return fmt.Errorf("HOST_NAME_TEMPLATE is invalid: %s", err)
+				}
+			}
+		}
+	}
+
+	for _, key := range []string{"SLACK_WEBHOOK_URL", "DIGEST_SLACK_WEBHOOK_URL", "TEAMS_WEBHOOK_URL", "OPSGENIE_API_URL"} {
+		if s := getenv(key); s != "" {
+			if err := validateURL(key, s); err != nil {
+				return err
+			}
+		}
+	}
+
+	if _, err := c.message(sampleAlarm, StatusWarning, "", ""); err != nil {
+		return fmt.Errorf("MESSAGE_TEMPLATE is invalid: %s", err)
+	}
+	if _, err := c.checkName(sampleAlarm); err != nil {
+		return fmt.Errorf("CHECK_NAME_TEMPLATE is invalid: %s", err)
+	}
+	if _, err := rewriteName(c.rewriteRules, sampleAlarm.AlarmName, sampleAlarm); err != nil {
+		return fmt.Errorf("NAME_REWRITE_RULES is invalid: %s", err)
+	}
+	sampleReport := Report{
+		Source:  Source{Type: "host", HostID: "2eQGEaLxibb"},
+		Name:    sampleAlarm.AlarmName,
+		Status:  StatusWarning,
+		Message: sampleAlarm.NewStateReason,
+		alarm:   &sampleAlarm,
+	}
+	for _, n := range c.notifiers {
+		switch n := n.Notifier.(type) {
+		case *slackNotifier:
+			if err := n.tmpl.Execute(ioutil.Discard, sampleReport); err != nil {
+				return fmt.Errorf("SLACK_TEMPLATE is invalid: %s", err)
+			}
+		case *webhook:
+			if err := validateURL("WEBHOOKS", n.URL); err != nil {
+				return err
+			}
+			if n.tmpl == nil {
+				continue
+			}
+			var b bytes.Buffer
+			if err := n.tmpl.Execute(&b, sampleReport); err != nil {
+				return fmt.Errorf("WEBHOOKS is invalid: %s: %s", n.URL, err)
+			}
+			if !json.Valid(b.Bytes()) {
+				return fmt.Errorf("WEBHOOKS is invalid: %s: the body is not JSON: %s", n.URL, b.String())
+			}
+		}
+	}
+
+	for _, s := range dependentSettings {
+		if getenv(s.key) != "" {
+			continue
+		}
+		for _, d := range s.dependents {
+			if getenv(d) != "" {
+				return fmt.Errorf("%s is set, but has no effect without %s", d, s.key)
+			}
+		}
+	}
+	return nil
+}
+
+func validateHostID(name, id string) error {
+	if !hostIDPattern.MatchString(id) {
+		return fmt.Errorf("%s: %q does not look like the id of a mackerel host", name, id)
+	}
+	return nil
+}
+
+func validateURL(name, s string) error {
+	u, err := url.Parse(s)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%s: %q is not a URL", name, s)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package cwa2mkr
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+const defaultVerifyDelay = 10 * time.Second
+
+// scheduleVerification enqueues the reports posted to RETRY_QUEUE_URL, delayed by VERIFY_DELAY_SECONDS,
+// not to wait in the invocation. the failures are only logged, as the reports are delivered already.
+func (f *forwarder) scheduleVerification(ctx context.Context, reps Reports) {
+	if err := f.retryQueue.enqueueVerification(ctx, reps, f.conf.verifyDelay); err != nil {
+		log.Printf("failed to schedule the verification of the delivery: %s", err)
+	}
+}
+
+// verifyDelivery confirms the statuses of the reports took effect in mackerel by the open alerts,
+// when the message of scheduleVerification is drained.
+// a non-OK report without the open alert of the status, or an OK report with an open alert is a discrepancy,
+// which is logged and counted as DeliveryDiscrepancies metric. the failures to verify are only logged.
+func (f *forwarder) verifyDelivery(ctx context.Context, reps Reports) {
+	type checkKey struct {
+		hostID string
+		name   string
+	}
+	// the alerts are listed once for each organization
+	alerted := make(map[*mackerelClient]map[checkKey]string)
+	for _, rep := range reps.Reports {
+		client := f.clientFor(rep.Source)
+		statuses, ok := alerted[client]
+		if !ok {
+			alerts, err := openCheckAlerts(ctx, client)
+			if err != nil {
+				log.Printf("failed to verify the delivery: %s", err)
+				return
+			}
+			statuses = make(map[checkKey]string)
+			for _, a := range alerts {
+				statuses[checkKey{hostID: a.HostID, name: a.name}] = a.Status
+			}
+			alerted[client] = statuses
+		}
+
+		got, open := statuses[checkKey{hostID: rep.Source.HostID, name: rep.Name}]
+		want := rep.Status
+		if want == StatusOK && !open || want != StatusOK && got == want {
+			continue
+		}
+		if !open {
+			got = "no open alert"
+		}
+		log.Printf("the delivery of %s on %s is not confirmed: reported %s, but %s", rep.Name, rep.Source.HostID, want, got)
+		statsFrom(ctx).discrepancy()
+	}
+}
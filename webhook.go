@@ -0,0 +1,108 @@
+package cwa2mkr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"text/template"
+)
+
+// postJSON posts the body encoded as JSON to the url, for webhooks of other services than mackerel.
+func postJSON(ctx context.Context, url string, body interface{}, header http.Header) error {
+	b := new(bytes.Buffer)
+	if err := json.NewEncoder(b).Encode(body); err != nil {
+		return err
+	}
+	req, cancel, err := newRequest(ctx, http.MethodPost, url, b)
+	if err != nil {
+		return err
+	}
+	defer cancel()
+
+	for k, vs := range header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-type", "application/json")
+	resp, err := sendRequest(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if status := resp.StatusCode; status >= 400 {
+		body, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return fmt.Errorf("failed to read response body: status code %d %s", status, err)
+		}
+		return fmt.Errorf("failed to post: status code %d %s", status, string(body))
+	}
+	return nil
+}
+
+// webhook posts each report to the url along with mackerel.
+//
+//	[
+//	  {"url": "https://example.com/alarms", "headers": {"Authorization": "Bearer xxx"}},
+//	  {"url": "https://example.com/hook", "template": "{\"text\": {{ json .Message }}}"}
+//	]
+type webhook struct {
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+
+	// text/template of the body executed with Report, the report itself is posted by default.
+	// the result must be JSON, and the function "json" encodes a value to JSON.
+	Template string `json:"template"`
+
+	header http.Header
+	tmpl   *template.Template
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parseWebhooks(s string) ([]*webhook, error) {
+	var hooks []*webhook
+	if err := json.Unmarshal([]byte(s), &hooks); err != nil {
+		return nil, err
+	}
+	for i, h := range hooks {
+		if h.URL == "" {
+			return nil, fmt.Errorf("webhook[%d]: url is required", i)
+		}
+		h.header = make(http.Header)
+		for k, v := range h.Headers {
+			h.header.Set(k, v)
+		}
+		if h.Template != "" {
+			tmpl, err := template.New(fmt.Sprintf("webhook%d", i)).Funcs(webhookFuncs).Parse(h.Template)
+			if err != nil {
+				return nil, fmt.Errorf("webhook[%d]: %s", i, err)
+			}
+			h.tmpl = tmpl
+		}
+	}
+	return hooks, nil
+}
+
+func (h *webhook) Notify(ctx context.Context, rep Report) error {
+	if h.tmpl == nil {
+		return postJSON(ctx, h.URL, rep, h.header)
+	}
+	var b bytes.Buffer
+	if err := h.tmpl.Execute(&b, rep); err != nil {
+		return err
+	}
+	if !json.Valid(b.Bytes()) {
+		return fmt.Errorf("the body is not JSON: %s", b.String())
+	}
+	return postJSON(ctx, h.URL, json.RawMessage(b.Bytes()), h.header)
+}